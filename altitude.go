@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// altitudeBands are the upper bounds in feet of every altitude band but the
+// last, which is open ended. The default splits low, medium and high level
+// traffic at 10,000 and 25,000 ft.
+var altitudeBands = []int{10000, 25000}
+
+// setAltitudeBands parses a comma separated list of band boundaries in feet.
+func setAltitudeBands(value string) error {
+	var bands []int
+	for _, item := range splitList(value) {
+		feet, err := strconv.Atoi(item)
+		if err != nil || feet <= 0 {
+			return fmt.Errorf("invalid altitude band boundary %q", item)
+		}
+		bands = append(bands, feet)
+	}
+	sort.Ints(bands)
+	for i := 1; i < len(bands); i++ {
+		if bands[i] == bands[i-1] {
+			return fmt.Errorf("duplicate altitude band boundary %d", bands[i])
+		}
+	}
+
+	altitudeBands = bands
+	return nil
+}
+
+// altitudeBandNames returns the label of every band, lowest first, e.g.
+// 0-10000, 10000-25000 and 25000+.
+func altitudeBandNames() []string {
+	names := make([]string, 0, len(altitudeBands)+1)
+	lower := 0
+	for _, upper := range altitudeBands {
+		names = append(names, fmt.Sprintf("%d-%d", lower, upper))
+		lower = upper
+	}
+	return append(names, fmt.Sprintf("%d+", lower))
+}
+
+// altitudeBand returns the band an aircraft is flying in, preferring the
+// barometric altitude, or "unknown" when it has not reported one. Aircraft
+// on the ground are in the lowest band.
+func altitudeBand(a Aircraft) string {
+	alt := a.AltoBaro
+	if alt == (Altitude{}) {
+		alt = a.AltoGeom
+	}
+	if alt == (Altitude{}) {
+		return "unknown"
+	}
+
+	names := altitudeBandNames()
+	for i, upper := range altitudeBands {
+		if alt.Feet < upper {
+			return names[i]
+		}
+	}
+	return names[len(names)-1]
+}
+
+// bandSector is one cell of the range by altitude and bearing breakdown.
+type bandSector struct {
+	band string
+	sector
+}
+
+func (b bandSector) labels(timePeriod string) prometheus.Labels {
+	labels := b.sector.labels(timePeriod)
+	labels["altitude_band"] = b.band
+	return labels
+}
+
+// bandSectors returns every altitude band and sector combination.
+func bandSectors() []bandSector {
+	var cells []bandSector
+	for _, band := range append(altitudeBandNames(), "unknown") {
+		for _, s := range sectors() {
+			cells = append(cells, bandSector{band: band, sector: s})
+		}
+	}
+	return cells
+}
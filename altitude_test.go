@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAltitudeBand(t *testing.T) {
+	var tests = []struct {
+		aircraft Aircraft
+		want     string
+	}{
+		{Aircraft{AltoBaro: Altitude{Ground: true}}, "0-10000"},
+		{Aircraft{AltoBaro: Altitude{Feet: 9999}}, "0-10000"},
+		{Aircraft{AltoBaro: Altitude{Feet: 10000}}, "10000-25000"},
+		{Aircraft{AltoGeom: Altitude{Feet: 37000}}, "25000+"},
+		{Aircraft{}, "unknown"},
+	}
+
+	for _, tt := range tests {
+		if got := altitudeBand(tt.aircraft); got != tt.want {
+			t.Errorf("altitudeBand(%+v) = %s, want %s", tt.aircraft.AltoBaro, got, tt.want)
+		}
+	}
+
+	defer setAltitudeBands("10000,25000")
+	if err := setAltitudeBands("18000, 5000"); err != nil {
+		t.Fatal(err)
+	}
+	if got := altitudeBandNames(); len(got) != 3 || got[0] != "0-5000" || got[2] != "18000+" {
+		t.Errorf("got bands %v", got)
+	}
+	for _, value := range []string{"abc", "-100", "5000,5000"} {
+		if err := setAltitudeBands(value); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestAircraftMetricsAltitude(t *testing.T) {
+	ReceiverLat, ReceiverLon = 51, -114
+	defer func() { ReceiverLat, ReceiverLon = 0, 0 }()
+	useFreshTrackers(t)
+
+	aircraftMetrics(AircraftList{Aircraft: []Aircraft{
+		{Hex: "c00001", Latitude: 51.5, Longitude: -114, AltoBaro: Altitude{Feet: 3000}},
+		{Hex: "c00002", Latitude: 52, Longitude: -114, AltoBaro: Altitude{Feet: 37000}},
+		{Hex: "c00003", Latitude: 51.2, Longitude: -114, AltoBaro: Altitude{Feet: 38000}},
+	}})
+
+	if got := testutil.ToFloat64(dump1090CountByAltitude.WithLabelValues("25000+", "N", "0", "latest")); got != 2 {
+		t.Errorf("got %f high aircraft to the north, want 2", got)
+	}
+	if got := testutil.ToFloat64(dump1090CountByAltitude.WithLabelValues("10000-25000", "N", "0", "latest")); got != 0 {
+		t.Errorf("got %f medium aircraft to the north, want 0", got)
+	}
+
+	low := testutil.ToFloat64(dump1090MaxRangeAltitude.WithLabelValues("0-10000", "N", "0", "latest"))
+	high := testutil.ToFloat64(dump1090MaxRangeAltitude.WithLabelValues("25000+", "N", "0", "latest"))
+	if low < 55000 || low > 56000 || high < 111000 || high > 112000 {
+		t.Errorf("got max range %f low and %f high", low, high)
+	}
+}
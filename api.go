@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-memdb"
+	"github.com/rs/zerolog/log"
+)
+
+// apiSearchLimit is how many aircraft a search returns unless asked for
+// fewer.
+const apiSearchLimit = 100
+
+// liveAircraft holds the aircraft of the last read so the API can merge
+// their current state with the database.
+var liveAircraft = &liveState{}
+
+type liveState struct {
+	mu       sync.RWMutex
+	aircraft map[string]Aircraft
+}
+
+func (l *liveState) set(aircraft []Aircraft) {
+	byHex := make(map[string]Aircraft, len(aircraft))
+	for _, a := range aircraft {
+		byHex[strings.ToLower(a.Hex)] = a
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.aircraft = byHex
+}
+
+func (l *liveState) get(hex string) (Aircraft, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	a, ok := l.aircraft[strings.ToLower(hex)]
+	return a, ok
+}
+
+// apiAircraft is an aircraft database entry merged with the aircraft's state
+// from the last read, if it is in view.
+type apiAircraft struct {
+	*AircraftDetails
+	Live *Aircraft `json:"live,omitempty"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func newApiAircraft(details *AircraftDetails) apiAircraft {
+	result := apiAircraft{AircraftDetails: details}
+	if live, ok := liveAircraft.get(details.Icao24); ok {
+		result.Live = &live
+	}
+	return result
+}
+
+// registerApi adds the aircraft database endpoints to mux:
+//
+//	/api/aircraft/{hex}                      one aircraft by ICAO24 address
+//	/api/registration/{reg}                  aircraft with a registration
+//	/api/search?model=&manufacturer_icao=    prefix search, case-insensitive
+//	/api/flights?since=&hex=&callsign=       completed visits, most recent first
+func registerApi(mux *http.ServeMux) {
+	mux.HandleFunc("/api/aircraft/", apiAircraftHandler)
+	mux.HandleFunc("/api/registration/", apiRegistrationHandler)
+	mux.HandleFunc("/api/search", apiSearchHandler)
+	mux.HandleFunc("/api/flights", apiFlightsHandler)
+}
+
+func apiAircraftHandler(w http.ResponseWriter, r *http.Request) {
+	hex := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/api/aircraft/"))
+	if _, ok := parseIcao(hex); !ok || strings.Contains(hex, "/") {
+		writeJson(w, http.StatusBadRequest, apiError{"invalid hex address"})
+		return
+	}
+
+	details, found, err := FindAircraft(hex)
+	if err != nil && err != errNoAircraftDb {
+		writeJson(w, http.StatusInternalServerError, apiError{err.Error()})
+		return
+	}
+	if !found {
+		if _, inView := liveAircraft.get(hex); !inView {
+			writeJson(w, http.StatusNotFound, apiError{"aircraft not found"})
+			return
+		}
+		// Aircraft in view but missing from the database still get their
+		// live state.
+		details = &AircraftDetails{Icao24: hex}
+	}
+
+	writeJson(w, http.StatusOK, newApiAircraft(details))
+}
+
+func apiRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	registration := strings.TrimPrefix(r.URL.Path, "/api/registration/")
+	if registration == "" || strings.Contains(registration, "/") {
+		writeJson(w, http.StatusBadRequest, apiError{"invalid registration"})
+		return
+	}
+
+	aircraft, err := queryAircraft("registration", registration, "", apiSearchLimit)
+	if err != nil {
+		writeApiError(w, err)
+		return
+	}
+	if len(aircraft) == 0 {
+		writeJson(w, http.StatusNotFound, apiError{"aircraft not found"})
+		return
+	}
+	writeJson(w, http.StatusOK, aircraft)
+}
+
+func apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	model := query.Get("model")
+	manufacturer := query.Get("manufacturer_icao")
+
+	limit := apiSearchLimit
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 && l < limit {
+		limit = l
+	}
+
+	var aircraft []apiAircraft
+	var err error
+	switch {
+	case model != "":
+		aircraft, err = queryAircraft("model_prefix", model, manufacturer, limit)
+	case manufacturer != "":
+		aircraft, err = queryAircraft("manufacturerIcao_prefix", manufacturer, "", limit)
+	default:
+		writeJson(w, http.StatusBadRequest, apiError{"model or manufacturer_icao is required"})
+		return
+	}
+	if err != nil {
+		writeApiError(w, err)
+		return
+	}
+	writeJson(w, http.StatusOK, aircraft)
+}
+
+func apiFlightsHandler(w http.ResponseWriter, r *http.Request) {
+	if flights == nil {
+		writeJson(w, http.StatusServiceUnavailable, apiError{errNoFlightLog.Error()})
+		return
+	}
+
+	query := r.URL.Query()
+	q := flightQuery{
+		hex:          query.Get("hex"),
+		callsign:     query.Get("callsign"),
+		registration: query.Get("registration"),
+		limit:        apiSearchLimit,
+	}
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 && l < q.limit {
+		q.limit = l
+	}
+	for name, t := range map[string]*time.Time{"since": &q.since, "until": &q.until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeJson(w, http.StatusBadRequest, apiError{name + " must be an RFC 3339 time"})
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	writeJson(w, http.StatusOK, flights.query(q))
+}
+
+// queryAircraft returns up to limit aircraft matching value on index,
+// optionally only those whose ICAO manufacturer starts with manufacturer.
+func queryAircraft(index string, value string, manufacturer string, limit int) ([]apiAircraft, error) {
+	aircraftDb := currentDB()
+	if aircraftDb == nil {
+		return nil, errNoAircraftDb
+	}
+
+	txn := aircraftDb.Txn(false)
+	defer txn.Abort()
+
+	it, err := txn.Get("aircraft", index, value)
+	if err != nil {
+		return nil, err
+	}
+	if manufacturer != "" {
+		manufacturer = strings.ToLower(manufacturer)
+		it = memdb.NewFilterIterator(it, func(raw interface{}) bool {
+			return !strings.HasPrefix(strings.ToLower(raw.(*AircraftDetails).ManufacturerIcao), manufacturer)
+		})
+	}
+
+	aircraft := []apiAircraft{}
+	for raw := it.Next(); raw != nil && len(aircraft) < limit; raw = it.Next() {
+		aircraft = append(aircraft, newApiAircraft(raw.(*AircraftDetails)))
+	}
+	return aircraft, nil
+}
+
+func writeApiError(w http.ResponseWriter, err error) {
+	if err == errNoAircraftDb {
+		writeJson(w, http.StatusServiceUnavailable, apiError{err.Error()})
+		return
+	}
+	writeJson(w, http.StatusInternalServerError, apiError{err.Error()})
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Err(err).Msg("Error writing api response")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApi(t *testing.T) {
+	aircraftDb := dbSetup()
+	if err := loadAircraftDb(writeTestCsv(t), openskyLoader{}, aircraftDb, dbFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	setDB(aircraftDb)
+	liveAircraft.set([]Aircraft{{Hex: "c05f0a", Flight: "WJA123"}, {Hex: "c0ffee"}})
+	defer func() {
+		setDB(nil)
+		liveAircraft.set(nil)
+	}()
+
+	mux := http.NewServeMux()
+	registerApi(mux)
+
+	var tests = []struct {
+		path   string
+		status int
+		count  int
+		first  string
+		live   bool
+	}{
+		{"/api/aircraft/C05F0A", http.StatusOK, 1, "C-FABC", true},
+		{"/api/aircraft/a0b1c2", http.StatusOK, 1, "N12345", false},
+		{"/api/aircraft/c0ffee", http.StatusOK, 1, "", true},
+		{"/api/aircraft/abcdef", http.StatusNotFound, 0, "", false},
+		{"/api/aircraft/zzz", http.StatusBadRequest, 0, "", false},
+		{"/api/registration/c-fabc", http.StatusOK, 1, "C-FABC", true},
+		{"/api/registration/G-ABCD", http.StatusNotFound, 0, "", false},
+		{"/api/search?model=737", http.StatusOK, 1, "C-FABC", true},
+		{"/api/search?model=737&manufacturer_icao=cess", http.StatusOK, 0, "", false},
+		{"/api/search?manufacturer_icao=ces", http.StatusOK, 1, "N12345", false},
+		{"/api/search", http.StatusBadRequest, 0, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			// Single aircraft come back as an object, lookups and searches
+			// as a list.
+			var aircraft []apiAircraft
+			body := rec.Body.Bytes()
+			if body[0] == '{' {
+				body = append(append([]byte("["), body...), ']')
+			}
+			if err := json.Unmarshal(body, &aircraft); err != nil {
+				t.Fatal(err)
+			}
+			if len(aircraft) != tt.count {
+				t.Fatalf("got %d aircraft, want %d", len(aircraft), tt.count)
+			}
+			if tt.count == 0 {
+				return
+			}
+			if aircraft[0].Registration != tt.first || (aircraft[0].Live != nil) != tt.live {
+				t.Errorf("got %+v with live state %+v", aircraft[0].AircraftDetails, aircraft[0].Live)
+			}
+		})
+	}
+}
+
+func TestApiWithoutDatabase(t *testing.T) {
+	setDB(nil)
+	mux := http.NewServeMux()
+	registerApi(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/search?model=737", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
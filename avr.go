@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// avrTimestampLen is the number of hex digits of the 12MHz timestamp that
+// prefixes an '@' AVR frame.
+const avrTimestampLen = 12
+
+type avrFrame struct {
+	Timestamp uint64
+	Data      []byte
+}
+
+// parseAVR parses one AVR line, either "*<hex>;" or the MLAT variant
+// "@<12 digit timestamp><hex>;".
+func parseAVR(line string) (avrFrame, error) {
+	line = strings.TrimSpace(line)
+	if len(line) < 2 || !strings.HasSuffix(line, ";") {
+		return avrFrame{}, fmt.Errorf("malformed AVR frame %q", line)
+	}
+
+	var frame avrFrame
+	body := line[1 : len(line)-1]
+
+	switch line[0] {
+	case '*':
+	case '@':
+		if len(body) < avrTimestampLen {
+			return avrFrame{}, fmt.Errorf("short AVR timestamp in %q", line)
+		}
+		ts, err := hex.DecodeString(body[:avrTimestampLen])
+		if err != nil {
+			return avrFrame{}, err
+		}
+		for _, b := range ts {
+			frame.Timestamp = frame.Timestamp<<8 | uint64(b)
+		}
+		body = body[avrTimestampLen:]
+	default:
+		return avrFrame{}, fmt.Errorf("unsupported AVR frame %q", line)
+	}
+
+	data, err := hex.DecodeString(body)
+	if err != nil {
+		return avrFrame{}, err
+	}
+	if len(data) != modesShortLen && len(data) != modesLongLen {
+		return avrFrame{}, errModeSLength
+	}
+	frame.Data = data
+
+	return frame, nil
+}
+
+// readAVR reads AVR frames from r into the aircraft table until the stream
+// ends or fails.
+func readAVR(r io.Reader, table *aircraftTable) error {
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		frame, err := parseAVR(line)
+		if err == nil {
+			_, err = table.handleModeS("avr", frame.Data, func(m *modesMessage) {
+				m.Mlat = frame.Timestamp == beastMlatTimestamp
+			})
+		}
+		if err != nil {
+			opsMetrics.StreamErrors(streamLabels{Source: "avr"}).Inc()
+			log.Debug().Err(err).Str("line", line).Msg("Skipping AVR frame")
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// streamAVR keeps a connection to a raw AVR feed (dump1090 port 30002) open
+// and feeds every decoded frame into the aircraft table.
+func streamAVR(addr string, table *aircraftTable) {
+	streamConnect("avr", addr, func(r io.Reader) error {
+		return readAVR(r, table)
+	})
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// basestationLoader reads the Aircraft table of a Kinetic BaseStation.sqb.
+type basestationLoader struct{}
+
+func (basestationLoader) load(path string, add func(*AircraftDetails) error) error {
+	db, err := openSqlite(path)
+	if err != nil {
+		return err
+	}
+
+	return db.scan("Aircraft", func(row map[string]interface{}) error {
+		return add(&AircraftDetails{
+			Icao24:           strings.ToLower(sqliteText(row["ModeS"])),
+			Registration:     sqliteText(row["Registration"]),
+			ManufacturerName: sqliteText(row["Manufacturer"]),
+			Model:            sqliteText(row["Type"]),
+			Typecode:         sqliteText(row["ICAOTypeCode"]),
+			Operator:         sqliteText(row["RegisteredOwners"]),
+			Interesting:      sqliteBool(row["Interested"]),
+		})
+	})
+}
+
+const sqliteMagic = "SQLite format 3\x00"
+
+// sqliteFile is just enough of a SQLite reader to walk the rows of a table.
+// It reads the main database file only, so changes still sitting in a -wal
+// file are not seen until they are checkpointed.
+type sqliteFile struct {
+	data       []byte
+	pageSize   int
+	usableSize int
+}
+
+func openSqlite(path string) (*sqliteFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 100 || string(data[:16]) != sqliteMagic {
+		return nil, errors.New("not a SQLite database")
+	}
+
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid SQLite page size %d", pageSize)
+	}
+	if encoding := binary.BigEndian.Uint32(data[56:60]); encoding > 1 {
+		return nil, errors.New("only UTF-8 SQLite databases are supported")
+	}
+
+	return &sqliteFile{
+		data:       data,
+		pageSize:   pageSize,
+		usableSize: pageSize - int(data[20]),
+	}, nil
+}
+
+// page returns page n, counting from 1 like SQLite does.
+func (f *sqliteFile) page(n uint32) ([]byte, error) {
+	start := (int(n) - 1) * f.pageSize
+	if n == 0 || start+f.pageSize > len(f.data) {
+		return nil, fmt.Errorf("SQLite page %d out of range", n)
+	}
+	return f.data[start : start+f.pageSize], nil
+}
+
+// scan calls fn with every row of table, keyed by column name.
+func (f *sqliteFile) scan(table string, fn func(map[string]interface{}) error) error {
+	var root uint32
+	var columns []string
+
+	// sqlite_schema is rooted on page 1: type, name, tbl_name, rootpage, sql.
+	err := f.walk(1, func(rowid int64, values []interface{}) error {
+		if len(values) < 5 || sqliteText(values[0]) != "table" || !strings.EqualFold(sqliteText(values[1]), table) {
+			return nil
+		}
+		page, _ := values[3].(int64)
+		root = uint32(page)
+		columns = sqliteColumns(sqliteText(values[4]))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if root == 0 {
+		return fmt.Errorf("no %s table", table)
+	}
+
+	return f.walk(root, func(rowid int64, values []interface{}) error {
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if i < len(values) {
+				row[column] = values[i]
+			}
+		}
+		return fn(row)
+	})
+}
+
+// walk visits every row of the table b-tree rooted at page n in rowid order.
+func (f *sqliteFile) walk(n uint32, fn func(int64, []interface{}) error) error {
+	return f.walkPage(n, make(map[uint32]bool), fn)
+}
+
+// walkPage walks the subtree at page n. visited holds the pages already
+// walked, so a corrupt file whose pages point back at each other fails
+// instead of recursing forever.
+func (f *sqliteFile) walkPage(n uint32, visited map[uint32]bool, fn func(int64, []interface{}) error) error {
+	if visited[n] {
+		return fmt.Errorf("SQLite page %d is referenced twice", n)
+	}
+	visited[n] = true
+
+	page, err := f.page(n)
+	if err != nil {
+		return err
+	}
+
+	header := 0
+	if n == 1 {
+		header = 100
+	}
+	if header+12 > len(page) {
+		return fmt.Errorf("SQLite page %d truncated", n)
+	}
+	kind := page[header]
+	cells := int(binary.BigEndian.Uint16(page[header+3:]))
+
+	// The cell pointer array follows the 12 byte interior or 8 byte leaf
+	// page header.
+	pointers := header + 8
+	if kind == 0x05 {
+		pointers = header + 12
+	}
+	if pointers+2*cells > len(page) {
+		return fmt.Errorf("SQLite page %d has %d cells, more than fit in the page", n, cells)
+	}
+
+	switch kind {
+	case 0x05: // interior table page
+		for i := 0; i < cells; i++ {
+			offset := int(binary.BigEndian.Uint16(page[pointers+2*i:]))
+			if offset+4 > len(page) {
+				return fmt.Errorf("SQLite page %d cell %d out of range", n, i)
+			}
+			if err := f.walkPage(binary.BigEndian.Uint32(page[offset:]), visited, fn); err != nil {
+				return err
+			}
+		}
+		return f.walkPage(binary.BigEndian.Uint32(page[header+8:]), visited, fn)
+
+	case 0x0d: // leaf table page
+		for i := 0; i < cells; i++ {
+			offset := int(binary.BigEndian.Uint16(page[pointers+2*i:]))
+			rowid, payload, err := f.cell(page, offset)
+			if err != nil {
+				return fmt.Errorf("SQLite page %d cell %d: %w", n, i, err)
+			}
+			values, err := sqliteRecord(payload)
+			if err != nil {
+				return fmt.Errorf("SQLite page %d cell %d: %w", n, i, err)
+			}
+			if err := fn(rowid, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("SQLite page %d is not a table page", n)
+}
+
+// cell returns the rowid and payload of the leaf cell at offset, following
+// overflow pages when the payload does not fit in the page.
+func (f *sqliteFile) cell(page []byte, offset int) (int64, []byte, error) {
+	if offset >= len(page) {
+		return 0, nil, errors.New("cell out of range")
+	}
+	size, n := sqliteVarint(page[offset:])
+	offset += n
+	rowid, n := sqliteVarint(page[offset:])
+	offset += n
+
+	total := int(size)
+	local := f.localPayload(total)
+	if offset+local > len(page) {
+		return 0, nil, errors.New("payload out of range")
+	}
+	payload := make([]byte, 0, total)
+	payload = append(payload, page[offset:offset+local]...)
+	if local == total {
+		return int64(rowid), payload, nil
+	}
+
+	if offset+local+4 > len(page) {
+		return 0, nil, errors.New("overflow pointer out of range")
+	}
+	next := binary.BigEndian.Uint32(page[offset+local:])
+	for len(payload) < total {
+		overflow, err := f.page(next)
+		if err != nil {
+			return 0, nil, err
+		}
+		next = binary.BigEndian.Uint32(overflow)
+		chunk := overflow[4:f.usableSize]
+		if remaining := total - len(payload); len(chunk) > remaining {
+			chunk = chunk[:remaining]
+		}
+		payload = append(payload, chunk...)
+	}
+	return int64(rowid), payload, nil
+}
+
+// localPayload is how much of a table leaf payload is stored in the page
+// itself, as defined by the SQLite file format.
+func (f *sqliteFile) localPayload(total int) int {
+	maxLocal := f.usableSize - 35
+	if total <= maxLocal {
+		return total
+	}
+	minLocal := (f.usableSize-12)*32/255 - 23
+	local := minLocal + (total-minLocal)%(f.usableSize-4)
+	if local > maxLocal {
+		return minLocal
+	}
+	return local
+}
+
+// sqliteRecord decodes a record into int64, float64, string, []byte or nil
+// values.
+func sqliteRecord(payload []byte) ([]interface{}, error) {
+	headerSize, n := sqliteVarint(payload)
+	if int(headerSize) > len(payload) || n == 0 {
+		return nil, errors.New("record header out of range")
+	}
+
+	var values []interface{}
+	body := int(headerSize)
+	for pos := n; pos < int(headerSize); {
+		serial, n := sqliteVarint(payload[pos:int(headerSize)])
+		if n == 0 {
+			return nil, errors.New("truncated record header")
+		}
+		pos += n
+
+		size := sqliteSerialSize(serial)
+		if body+size > len(payload) {
+			return nil, errors.New("record value out of range")
+		}
+		value := payload[body : body+size]
+		body += size
+
+		switch {
+		case serial == 0:
+			values = append(values, nil)
+		case serial <= 6:
+			var v int64
+			for _, b := range value {
+				v = v<<8 | int64(b)
+			}
+			// Sign extend from the stored width.
+			shift := 64 - 8*uint(size)
+			values = append(values, v<<shift>>shift)
+		case serial == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(value)))
+		case serial == 8:
+			values = append(values, int64(0))
+		case serial == 9:
+			values = append(values, int64(1))
+		case serial >= 12 && serial%2 == 0:
+			values = append(values, append([]byte(nil), value...))
+		case serial >= 13:
+			values = append(values, string(value))
+		default:
+			return nil, fmt.Errorf("invalid serial type %d", serial)
+		}
+	}
+	return values, nil
+}
+
+func sqliteSerialSize(serial uint64) int {
+	switch {
+	case serial <= 4:
+		return int(serial)
+	case serial == 5:
+		return 6
+	case serial == 6 || serial == 7:
+		return 8
+	case serial >= 12:
+		return int(serial-12) / 2
+	}
+	return 0
+}
+
+// sqliteVarint decodes a SQLite big-endian varint, returning the value and
+// the number of bytes read, or 0 bytes if b is too short.
+func sqliteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return v, 9
+}
+
+// sqliteColumns returns the column names of a CREATE TABLE statement.
+func sqliteColumns(sql string) []string {
+	start := strings.Index(sql, "(")
+	end := strings.LastIndex(sql, ")")
+	if start < 0 || end <= start {
+		return nil
+	}
+
+	var defs []string
+	depth, last := 0, start+1
+	for i := start + 1; i < end; i++ {
+		switch sql[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				defs = append(defs, sql[last:i])
+				last = i + 1
+			}
+		}
+	}
+	defs = append(defs, sql[last:end])
+
+	var columns []string
+	for _, def := range defs {
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+		columns = append(columns, strings.Trim(fields[0], "\"`[]"))
+	}
+	return columns
+}
+
+func sqliteText(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+func sqliteBool(v interface{}) bool {
+	switch v := v.(type) {
+	case int64:
+		return v != 0
+	case string:
+		return v == "1" || strings.EqualFold(v, "true")
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	beastEscape     = 0x1a
+	beastModeAC     = '1'
+	beastModeSShort = '2'
+	beastModeSLong  = '3'
+
+	// beastMlatTimestamp is the timestamp mlat-client puts on the frames it
+	// synthesises from multilateration results.
+	beastMlatTimestamp = 0xFF004D4C4154
+)
+
+var errBeastResync = errors.New("beast frame interrupted by a new frame")
+
+type beastFrame struct {
+	Type      byte
+	Timestamp uint64
+	Signal    byte
+	Data      []byte
+}
+
+// beastFrameLength returns the payload length of a frame type, excluding the
+// 6 byte timestamp and 1 byte signal level.
+func beastFrameLength(frameType byte) (int, bool) {
+	switch frameType {
+	case beastModeAC:
+		return 2, true
+	case beastModeSShort:
+		return modesShortLen, true
+	case beastModeSLong:
+		return modesLongLen, true
+	}
+	return 0, false
+}
+
+// readBeastBody reads n unescaped bytes. A lone escape byte means the sender
+// started a new frame, in which case the new frame type is returned.
+func readBeastBody(r *bufio.Reader, n int) ([]byte, byte, error) {
+	buf := make([]byte, 0, n)
+	for len(buf) < n {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, 0, err
+		}
+		if b == beastEscape {
+			next, err := r.ReadByte()
+			if err != nil {
+				return nil, 0, err
+			}
+			if next != beastEscape {
+				return nil, next, errBeastResync
+			}
+		}
+		buf = append(buf, b)
+	}
+	return buf, 0, nil
+}
+
+// readBeastFrame reads the next frame from a Beast binary stream, skipping
+// anything up to the next frame start if the stream is not aligned.
+func readBeastFrame(r *bufio.Reader) (beastFrame, error) {
+	var frameType byte
+
+	for {
+		if frameType == 0 {
+			b, err := r.ReadByte()
+			if err != nil {
+				return beastFrame{}, err
+			}
+			if b != beastEscape {
+				continue
+			}
+			if frameType, err = r.ReadByte(); err != nil {
+				return beastFrame{}, err
+			}
+		}
+
+		n, ok := beastFrameLength(frameType)
+		if !ok {
+			// Status frames and escaped data bytes; hunt for the next frame.
+			frameType = 0
+			continue
+		}
+
+		body, next, err := readBeastBody(r, 6+1+n)
+		if err == errBeastResync {
+			frameType = next
+			continue
+		}
+		if err != nil {
+			return beastFrame{}, err
+		}
+
+		var timestamp uint64
+		for _, b := range body[:6] {
+			timestamp = timestamp<<8 | uint64(b)
+		}
+
+		return beastFrame{
+			Type:      frameType,
+			Timestamp: timestamp,
+			Signal:    body[6],
+			Data:      body[7:],
+		}, nil
+	}
+}
+
+// beastSignal converts the Beast signal byte into dBFS, as aircraft.json
+// reports rssi.
+func beastSignal(level byte) float64 {
+	if level == 0 {
+		return -49.5
+	}
+	power := math.Pow(float64(level)/255, 2)
+	return 10 * math.Log10(power)
+}
+
+// readBeast reads Beast frames from r into the aircraft table until the
+// stream ends or fails.
+func readBeast(r io.Reader, table *aircraftTable) error {
+
+	reader := bufio.NewReader(r)
+	for {
+		frame, err := readBeastFrame(reader)
+		if err != nil {
+			return err
+		}
+
+		if frame.Type == beastModeAC {
+			opsMetrics.StreamMessages(streamMessageLabels{Source: "beast", Type: "mode_ac"}).Inc()
+			continue
+		}
+
+		_, err = table.handleModeS("beast", frame.Data, func(m *modesMessage) {
+			m.Signal = beastSignal(frame.Signal)
+			m.HasSignal = true
+			m.Mlat = frame.Timestamp == beastMlatTimestamp
+		})
+		if err != nil {
+			opsMetrics.StreamErrors(streamLabels{Source: "beast"}).Inc()
+			log.Debug().Err(err).Hex("frame", frame.Data).Msg("Skipping Beast frame")
+		}
+	}
+}
+
+// streamBeast keeps a connection to a Beast binary feed (dump1090 port
+// 30005) open and feeds every decoded frame into the aircraft table.
+func streamBeast(addr string, table *aircraftTable) {
+	streamConnect("beast", addr, func(r io.Reader) error {
+		return readBeast(r, table)
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	scrapeUpDesc = prometheus.NewDesc(
+		"dump1090_up",
+		"Whether the last read of the dump1090 json files succeeded.",
+		nil, nil,
+	)
+	scrapeDurationDesc = prometheus.NewDesc(
+		"dump1090_scrape_duration_seconds",
+		"Time taken to read and parse the dump1090 json files.",
+		nil, nil,
+	)
+)
+
+// captureRegisterer collects whatever is registered with it instead of
+// exposing it, so the scrape collector can gather those metrics itself.
+type captureRegisterer struct {
+	collectors []prometheus.Collector
+}
+
+func (r *captureRegisterer) Register(c prometheus.Collector) error {
+	r.collectors = append(r.collectors, c)
+	return nil
+}
+
+func (r *captureRegisterer) MustRegister(cs ...prometheus.Collector) {
+	r.collectors = append(r.collectors, cs...)
+}
+
+func (r *captureRegisterer) Unregister(c prometheus.Collector) bool {
+	return false
+}
+
+// scrapeCollector reads aircraft.json and stats.json inside Collect, so every
+// scrape sees the metrics of a single read instead of whatever state the
+// background readers were in.
+type scrapeCollector struct {
+	path       string
+	table      *aircraftTable
+	mu         sync.Mutex
+	collectors []prometheus.Collector
+}
+
+// newScrapeCollector rebuilds the exporter's metrics so they are only
+// reachable through the returned collector. When table is set, aircraft come
+// from the network feed instead of aircraft.json.
+func newScrapeCollector(path string, table *aircraftTable) *scrapeCollector {
+	capture := &captureRegisterer{}
+	registerMetrics(capture)
+
+	return &scrapeCollector{
+		path:       path,
+		table:      table,
+		collectors: capture.collectors,
+	}
+}
+
+func (c *scrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors {
+		collector.Describe(ch)
+	}
+	ch <- scrapeUpDesc
+	ch <- scrapeDurationDesc
+}
+
+func (c *scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := time.Now()
+	up := 1.0
+	if err := c.scrape(); err != nil {
+		up = 0
+		log.Error().Err(err).Msg("Error reading dump1090 json files")
+	}
+	duration := time.Since(start).Seconds()
+
+	for _, collector := range c.collectors {
+		collector.Collect(ch)
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeUpDesc, prometheus.GaugeValue, up)
+	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration)
+}
+
+// scrape refreshes every metric from the json files. A missing stats.json is
+// not an error since receivers fed over the network may not have one.
+func (c *scrapeCollector) scrape() error {
+
+	if c.table != nil {
+		aircraftMetrics(c.table.snapshot(time.Now()))
+	} else {
+		aircraftList, err := loadAircraftFile(c.path)
+		if err != nil {
+			return err
+		}
+		aircraftMetrics(aircraftList)
+	}
+
+	stats, err := loadStatsFile(c.path)
+	if errors.Is(err, os.ErrNotExist) && c.table != nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	statMetrics(stats)
+
+	return nil
+}
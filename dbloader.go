@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gocarina/gocsv"
+)
+
+// aircraftLoader reads one kind of aircraft database. Every loader feeds the
+// same memdb aircraft table, so filtering and lookups do not care where the
+// details came from.
+type aircraftLoader interface {
+	// load calls add for every aircraft in the database at path, stopping at
+	// the first error.
+	load(path string, add func(*AircraftDetails) error) error
+}
+
+// aircraftLoaders are the database formats that can be selected with
+// -db-format.
+var aircraftLoaders = map[string]aircraftLoader{
+	"opensky":     openskyLoader{},
+	"mictronics":  mictronicsLoader{},
+	"basestation": basestationLoader{},
+}
+
+// detectLoader picks the loader for the database at path: a directory is a
+// tar1090 Mictronics database, a SQLite file is a Kinetic BaseStation.sqb and
+// anything else is taken to be the OpenSky CSV.
+func detectLoader(path string) (aircraftLoader, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return mictronicsLoader{}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, len(sqliteMagic))
+	if _, err := io.ReadFull(f, header); err == nil && string(header) == sqliteMagic {
+		return basestationLoader{}, nil
+	}
+	return openskyLoader{}, nil
+}
+
+// openskyLoader reads the OpenSky Network aircraftDatabase.csv, optionally
+// gzipped.
+type openskyLoader struct{}
+
+func (openskyLoader) load(path string, add func(*AircraftDetails) error) error {
+	aircraftFile, err := openDbFile(path)
+	if err != nil {
+		return err
+	}
+	defer aircraftFile.Close()
+
+	aircraft := []*AircraftDetails{}
+	if err := gocsv.Unmarshal(aircraftFile, &aircraft); err != nil {
+		return err
+	}
+	for _, a := range aircraft {
+		if err := add(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mictronicsLoader reads the sharded json database tar1090 ships in its db
+// directory. Each file is named after a hex prefix and maps the rest of the
+// address onto the aircraft, e.g. A0.js holds "B1C2" for a0b1c2. The files
+// are usually gzipped whatever their extension.
+type mictronicsLoader struct{}
+
+// mictronicsEntry is one aircraft in a shard. Current databases store it as
+// an array, [registration, type, flags, description], older ones as an
+// object keyed r, t, f and d.
+type mictronicsEntry struct {
+	Registration string `json:"r"`
+	Type         string `json:"t"`
+	Flags        string `json:"f"`
+	Description  string `json:"d"`
+}
+
+func (e *mictronicsEntry) UnmarshalJSON(data []byte) error {
+	if len(data) == 0 || data[0] != '[' {
+		// The alias drops this method so the object decodes normally.
+		type object mictronicsEntry
+		return json.Unmarshal(data, (*object)(e))
+	}
+
+	var fields []interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	// Later databases append more fields, and missing ones are null.
+	for i, field := range []*string{&e.Registration, &e.Type, &e.Flags, &e.Description} {
+		if i < len(fields) {
+			*field, _ = fields[i].(string)
+		}
+	}
+	return nil
+}
+
+func (mictronicsLoader) load(path string, add func(*AircraftDetails) error) error {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	shards := 0
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".js" && ext != ".json") {
+			continue
+		}
+		prefix := strings.TrimSuffix(entry.Name(), ext)
+		if _, ok := parseIcao(prefix); !ok {
+			continue
+		}
+		if err := loadMictronicsShard(filepath.Join(path, entry.Name()), prefix, add); err != nil {
+			return fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		shards++
+	}
+
+	if shards == 0 {
+		return fmt.Errorf("no database shards in %s", path)
+	}
+	return nil
+}
+
+func loadMictronicsShard(path string, prefix string, add func(*AircraftDetails) error) error {
+	f, err := openDbFile(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var shard map[string]json.RawMessage
+	if err := json.NewDecoder(f).Decode(&shard); err != nil {
+		return err
+	}
+
+	for suffix, raw := range shard {
+		// "children" lists the shards split off this one.
+		if suffix == "children" {
+			continue
+		}
+		var entry mictronicsEntry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return fmt.Errorf("%s%s: %w", prefix, suffix, err)
+		}
+		err := add(&AircraftDetails{
+			Icao24:       strings.ToLower(prefix + suffix),
+			Registration: entry.Registration,
+			Model:        entry.Description,
+			Typecode:     entry.Type,
+			Military:     mictronicsFlag(entry.Flags, 0),
+			Interesting:  mictronicsFlag(entry.Flags, 1),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mictronicsFlag reports whether flag i is set in a flags string like "10",
+// where the first flag is military and the second interesting.
+func mictronicsFlag(flags string, i int) bool {
+	return len(flags) > i && flags[i] == '1'
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	// Embedded so -timezone works on images without a zoneinfo database.
+	_ "time/tzdata"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// distinctPeriods are the rolling windows distinct aircraft are counted over.
+var distinctPeriods = []struct {
+	name   string
+	window time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+}
+
+var distinctAircraft = newDistinctTracker(time.Local)
+
+// distinctTracker counts every hex heard, exactly, over the rolling windows
+// and since midnight in loc.
+type distinctTracker struct {
+	mu       sync.Mutex
+	loc      *time.Location
+	lastSeen map[string]time.Time
+	day      string
+	today    map[string]bool
+}
+
+func newDistinctTracker(loc *time.Location) *distinctTracker {
+	return &distinctTracker{
+		loc:      loc,
+		lastSeen: make(map[string]time.Time),
+		today:    make(map[string]bool),
+	}
+}
+
+// observe records the aircraft of one read, by when each was last heard.
+func (t *distinctTracker) observe(aircraft []Aircraft, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rollover(now)
+	for _, a := range aircraft {
+		heard := now.Add(-time.Duration(a.Seen * float64(time.Second)))
+		if heard.After(t.lastSeen[a.Hex]) {
+			t.lastSeen[a.Hex] = heard
+		}
+		if heard.In(t.loc).Format("2006-01-02") == t.day {
+			t.today[a.Hex] = true
+		}
+	}
+}
+
+// rollover starts a new calendar day when midnight has passed in loc.
+func (t *distinctTracker) rollover(now time.Time) {
+	if day := now.In(t.loc).Format("2006-01-02"); day != t.day {
+		t.day = day
+		t.today = make(map[string]bool)
+	}
+}
+
+// counts returns the distinct aircraft of every period, forgetting aircraft
+// that fell out of the longest window.
+func (t *distinctTracker) counts(now time.Time) map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rollover(now)
+	counts := map[string]int{"today": len(t.today)}
+	for _, period := range distinctPeriods {
+		counts[period.name] = 0
+	}
+
+	longest := distinctPeriods[len(distinctPeriods)-1].window
+	for hex, seen := range t.lastSeen {
+		age := now.Sub(seen)
+		if age > longest {
+			delete(t.lastSeen, hex)
+			continue
+		}
+		for _, period := range distinctPeriods {
+			if age <= period.window {
+				counts[period.name]++
+			}
+		}
+	}
+	return counts
+}
+
+// distinctFile is the on disk form of a distinctTracker.
+type distinctFile struct {
+	Day      string               `json:"day"`
+	Today    []string             `json:"today"`
+	LastSeen map[string]time.Time `json:"last_seen"`
+}
+
+// save writes the sets to path, replacing it atomically.
+func (t *distinctTracker) save(path string) error {
+	t.mu.Lock()
+	file := distinctFile{Day: t.day, LastSeen: make(map[string]time.Time, len(t.lastSeen))}
+	for hex := range t.today {
+		file.Today = append(file.Today, hex)
+	}
+	for hex, seen := range t.lastSeen {
+		file.LastSeen[hex] = seen
+	}
+	t.mu.Unlock()
+
+	return writeJsonFile(path, file)
+}
+
+// load restores the sets saved at path. A saved day other than today is
+// dropped. A missing file is not an error.
+func (t *distinctTracker) load(path string, now time.Time) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var file distinctFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rollover(now)
+	if file.Day == t.day {
+		for _, hex := range file.Today {
+			t.today[hex] = true
+		}
+	}
+	for hex, seen := range file.LastSeen {
+		if seen.After(t.lastSeen[hex]) {
+			t.lastSeen[hex] = seen
+		}
+	}
+	return nil
+}
+
+// distinctMetrics counts the distinct aircraft heard in one read.
+func distinctMetrics(aircraft []Aircraft, now time.Time) {
+
+	distinctAircraft.observe(aircraft, now)
+	for period, count := range distinctAircraft.counts(now) {
+		dump1090DistinctAircraft.With(prometheus.Labels{"time_period": period}).Set(float64(count))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDistinctTracker(t *testing.T) {
+	loc, err := time.LoadLocation("America/Edmonton")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := newDistinctTracker(loc)
+	// 22:00 local time.
+	now := time.Date(2023, 6, 1, 4, 0, 0, 0, time.UTC)
+
+	tracker.observe([]Aircraft{{Hex: "aaaaaa"}, {Hex: "bbbbbb"}}, now)
+	tracker.observe([]Aircraft{{Hex: "aaaaaa"}}, now.Add(90*time.Minute))
+	tracker.observe([]Aircraft{{Hex: "cccccc", Seen: 30}}, now.Add(3*time.Hour))
+
+	counts := tracker.counts(now.Add(3 * time.Hour))
+	want := map[string]int{"1h": 1, "24h": 3, "today": 1}
+	for period, count := range want {
+		if counts[period] != count {
+			t.Errorf("%s: got %d, want %d", period, counts[period], count)
+		}
+	}
+
+	// 23:00 local time, bbbbbb has dropped out of the last 24h.
+	counts = tracker.counts(now.Add(25 * time.Hour))
+	want = map[string]int{"1h": 0, "24h": 2, "today": 1}
+	for period, count := range want {
+		if counts[period] != count {
+			t.Errorf("end of day %s: got %d, want %d", period, counts[period], count)
+		}
+	}
+
+	if counts := tracker.counts(now.Add(26 * time.Hour)); counts["today"] != 0 {
+		t.Errorf("got %d aircraft after midnight, want 0", counts["today"])
+	}
+}
+
+func TestDistinctTrackerPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "distinct.json")
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tracker := newDistinctTracker(time.UTC)
+	tracker.observe([]Aircraft{{Hex: "aaaaaa"}, {Hex: "bbbbbb"}}, now)
+	if err := tracker.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newDistinctTracker(time.UTC)
+	if err := restored.load(path, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	restored.observe([]Aircraft{{Hex: "cccccc"}}, now.Add(time.Hour))
+	if counts := restored.counts(now.Add(time.Hour)); counts["today"] != 3 || counts["24h"] != 3 || counts["1h"] != 3 {
+		t.Errorf("got %v after restart", counts)
+	}
+
+	nextDay := newDistinctTracker(time.UTC)
+	if err := nextDay.load(path, now.Add(13*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if counts := nextDay.counts(now.Add(13 * time.Hour)); counts["today"] != 0 || counts["24h"] != 2 {
+		t.Errorf("got %v the next day", counts)
+	}
+
+	if err := newDistinctTracker(time.UTC).load(filepath.Join(t.TempDir(), "missing.json"), now); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestReadAircraftFileErrorSkipsDistinct(t *testing.T) {
+	useFreshTrackers(t)
+	defer dump1090DistinctAircraft.Reset()
+
+	// The aircraft decode, but the read as a whole fails and none of it
+	// is trusted.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "aircraft.json"), []byte(`{"messages": "many", "aircraft": [{"hex": "c05f0a"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	readAircraftFile(dir + "/")
+
+	if counts := distinctAircraft.counts(time.Now()); counts["24h"] != 0 {
+		t.Errorf("got %v after a failed read", counts)
+	}
+}
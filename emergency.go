@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// emergencySquawks maps the special purpose squawks onto the emergency
+// values dump1090 reports in aircraft.json.
+var emergencySquawks = map[string]string{
+	"7500": "unlawful",
+	"7600": "nordo",
+	"7700": "general",
+}
+
+var emergencies = newEmergencyTracker()
+
+type emergencyEvent struct {
+	Event    string    `json:"event"`
+	Hex      string    `json:"hex"`
+	Flight   string    `json:"flight,omitempty"`
+	Type     string    `json:"type"`
+	Squawk   string    `json:"squawk,omitempty"`
+	Lat      float64   `json:"lat,omitempty"`
+	Lon      float64   `json:"lon,omitempty"`
+	AltBaro  *Altitude `json:"alt_baro,omitempty"`
+	Since    time.Time `json:"since"`
+	Time     time.Time `json:"time"`
+	Duration float64   `json:"duration_seconds,omitempty"`
+}
+
+type emergencyState struct {
+	aircraft Aircraft
+	kind     string
+	since    time.Time
+	lastSeen time.Time
+}
+
+// emergencyTracker follows aircraft in an emergency across aircraft.json
+// reads so each emergency is logged and sent to the webhooks once when it
+// starts and once when it ends, however long it lasts.
+type emergencyTracker struct {
+	mu     sync.Mutex
+	active map[string]*emergencyState
+
+	// webhooks receive a JSON emergencyEvent for every start and end.
+	webhooks []string
+	// clearAfter is how long an aircraft has to stop reporting an
+	// emergency before it is considered over, so a squawk that flickers
+	// or an aircraft that briefly drops out does not raise a new alert.
+	clearAfter time.Duration
+}
+
+func newEmergencyTracker() *emergencyTracker {
+	return &emergencyTracker{
+		active:     make(map[string]*emergencyState),
+		clearAfter: 5 * time.Minute,
+	}
+}
+
+// emergencyType returns the kind of emergency an aircraft is in, or "" if
+// there is none. The emergency field wins over the squawk since it is set
+// from the aircraft's own emergency status message.
+func emergencyType(a Aircraft) string {
+	if a.Emergency != "" && a.Emergency != "none" {
+		return a.Emergency
+	}
+	return emergencySquawks[a.Squawk]
+}
+
+// observe updates the tracker from one read of aircraft and returns the
+// events for emergencies that started or ended.
+func (t *emergencyTracker) observe(aircraft []Aircraft, now time.Time) []emergencyEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var events []emergencyEvent
+
+	for _, a := range aircraft {
+		kind := emergencyType(a)
+		if kind == "" {
+			continue
+		}
+
+		state, ok := t.active[a.Hex]
+		if ok && state.kind != kind {
+			events = append(events, state.event("emergency_end", now))
+			ok = false
+		}
+		if !ok {
+			state = &emergencyState{aircraft: a, kind: kind, since: now}
+			t.active[a.Hex] = state
+			events = append(events, state.event("emergency_start", now))
+		}
+		state.aircraft = a
+		state.lastSeen = now
+	}
+
+	for hex, state := range t.active {
+		if now.Sub(state.lastSeen) >= t.clearAfter {
+			delete(t.active, hex)
+			events = append(events, state.event("emergency_end", now))
+		}
+	}
+
+	return events
+}
+
+// current returns the emergencies reported in the latest read.
+func (t *emergencyTracker) current(now time.Time) []emergencyState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var states []emergencyState
+	for _, state := range t.active {
+		if state.lastSeen.Equal(now) {
+			states = append(states, *state)
+		}
+	}
+	return states
+}
+
+func (s *emergencyState) event(name string, now time.Time) emergencyEvent {
+	event := emergencyEvent{
+		Event:  name,
+		Hex:    s.aircraft.Hex,
+		Flight: strings.TrimSpace(s.aircraft.Flight),
+		Type:   s.kind,
+		Squawk: s.aircraft.Squawk,
+		Lat:    s.aircraft.Latitude,
+		Lon:    s.aircraft.Longitude,
+		Since:  s.since,
+		Time:   now,
+	}
+	if s.aircraft.AltoBaro != (Altitude{}) {
+		alt := s.aircraft.AltoBaro
+		event.AltBaro = &alt
+	}
+	if name == "emergency_end" {
+		event.Duration = now.Sub(s.since).Seconds()
+	}
+	return event
+}
+
+// notify logs the event and posts it to every configured webhook.
+func (t *emergencyTracker) notify(event emergencyEvent) {
+
+	opsMetrics.EmergencyEvents(emergencyLabels{Event: event.Event, Type: event.Type}).Inc()
+	log.Warn().
+		Str("event", event.Event).
+		Str("hex", event.Hex).
+		Str("flight", event.Flight).
+		Str("type", event.Type).
+		Str("squawk", event.Squawk).
+		Time("since", event.Since).
+		Msg("Aircraft emergency")
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Error().Err(err).Msg("Error encoding emergency event")
+		return
+	}
+
+	for _, url := range t.webhooks {
+		go func(url string) {
+			if err := postWebhook(url, body); err != nil {
+				opsMetrics.EmergencyWebhookErrors().Inc()
+				log.Error().Err(err).Str("url", url).Msg("Error sending emergency webhook")
+			}
+		}(url)
+	}
+}
+
+func postWebhook(url string, body []byte) error {
+	resp, err := myClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// emergencyMetrics tracks emergencies in one read of aircraft and exposes
+// the aircraft currently reporting one.
+func emergencyMetrics(aircraft []Aircraft, now time.Time) {
+
+	for _, event := range emergencies.observe(aircraft, now) {
+		emergencies.notify(event)
+	}
+
+	dump1090EmergencyAircraft.Reset()
+	for _, state := range emergencies.current(now) {
+		dump1090EmergencyAircraft.With(prometheus.Labels{
+			"flight": strings.TrimSpace(state.aircraft.Flight),
+			"hex":    state.aircraft.Hex,
+			"type":   state.kind,
+		}).Set(1)
+	}
+}
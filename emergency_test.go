@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEmergencyType(t *testing.T) {
+	var tests = []struct {
+		aircraft Aircraft
+		want     string
+	}{
+		{Aircraft{Squawk: "7700"}, "general"},
+		{Aircraft{Squawk: "7600"}, "nordo"},
+		{Aircraft{Squawk: "7500", Emergency: "none"}, "unlawful"},
+		{Aircraft{Squawk: "7700", Emergency: "minfuel"}, "minfuel"},
+		{Aircraft{Squawk: "1200", Emergency: "none"}, ""},
+		{Aircraft{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.aircraft.Squawk+"/"+tt.aircraft.Emergency, func(t *testing.T) {
+			if got := emergencyType(tt.aircraft); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmergencyTrackerDeduplicates(t *testing.T) {
+	tracker := newEmergencyTracker()
+	tracker.clearAfter = time.Minute
+	now := time.Now()
+
+	emergency := []Aircraft{{Hex: "c0ffee", Squawk: "7700"}}
+	clear := []Aircraft{{Hex: "c0ffee", Squawk: "1200"}}
+
+	var steps = []struct {
+		aircraft []Aircraft
+		after    time.Duration
+		want     []string
+	}{
+		{emergency, 0, []string{"emergency_start"}},
+		{emergency, 5 * time.Second, nil},
+		// A brief gap does not end the emergency.
+		{clear, 10 * time.Second, nil},
+		{emergency, 15 * time.Second, nil},
+		{clear, 2 * time.Minute, []string{"emergency_end"}},
+		{emergency, 3 * time.Minute, []string{"emergency_start"}},
+		{[]Aircraft{{Hex: "c0ffee", Squawk: "7600"}}, 4 * time.Minute, []string{"emergency_end", "emergency_start"}},
+	}
+
+	for i, step := range steps {
+		events := tracker.observe(step.aircraft, now.Add(step.after))
+		if len(events) != len(step.want) {
+			t.Fatalf("step %d: got %d events, want %d", i, len(events), len(step.want))
+		}
+		for j, event := range events {
+			if event.Event != step.want[j] {
+				t.Errorf("step %d: got %s, want %s", i, event.Event, step.want[j])
+			}
+		}
+	}
+}
+
+// webhookServer records the body of every POST it gets.
+func webhookServer(t *testing.T) (string, <-chan []byte) {
+	t.Helper()
+	bodies := make(chan []byte, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s with content type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		bodies <- body
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, bodies
+}
+
+// nextWebhook waits for the next body posted to a webhookServer.
+func nextWebhook(t *testing.T, bodies <-chan []byte) []byte {
+	t.Helper()
+	select {
+	case body := <-bodies:
+		return body
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not posted")
+		return nil
+	}
+}
+
+func TestEmergencyWebhook(t *testing.T) {
+	url, bodies := webhookServer(t)
+	emergencies = newEmergencyTracker()
+	emergencies.webhooks = []string{url}
+	defer func() {
+		emergencies = newEmergencyTracker()
+		dump1090EmergencyAircraft.Reset()
+	}()
+
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	aircraft := []Aircraft{{Hex: "c0ffee", Flight: "WJA123  ", Squawk: "7700", Latitude: 51, Longitude: -114}}
+	emergencyMetrics(aircraft, now)
+	// The same emergency in the next read is not posted again.
+	emergencyMetrics(aircraft, now.Add(5*time.Second))
+
+	var event emergencyEvent
+	if err := json.Unmarshal(nextWebhook(t, bodies), &event); err != nil {
+		t.Fatal(err)
+	}
+	want := emergencyEvent{Event: "emergency_start", Hex: "c0ffee", Flight: "WJA123", Type: "general", Squawk: "7700", Lat: 51, Lon: -114, Since: now, Time: now}
+	if event != want {
+		t.Errorf("got %+v, want %+v", event, want)
+	}
+
+	select {
+	case body := <-bodies:
+		t.Errorf("got a second webhook %s", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -1,119 +1,300 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/gocarina/gocsv"
 	"github.com/hashicorp/go-memdb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
 )
 
 type AircraftDetails struct {
-	Icao24           string `csv:"icao24"`
-	Registration     string `csv:"registration"`
-	ManufacturerIcao string `csv:"manufacturericao"`
-	ManufacturerName string `csv:"manufacturername"`
-	Model            string `csv:"model"`
-	Operator         string `csv:"operator"`
+	Icao24           string `csv:"icao24" json:"icao24"`
+	Registration     string `csv:"registration" json:"registration,omitempty"`
+	ManufacturerIcao string `csv:"manufacturericao" json:"manufacturer_icao,omitempty"`
+	ManufacturerName string `csv:"manufacturername" json:"manufacturer_name,omitempty"`
+	Model            string `csv:"model" json:"model,omitempty"`
+	Typecode         string `csv:"typecode" json:"type_code,omitempty"`
+	Operator         string `csv:"operator" json:"operator,omitempty"`
+	// Military and Interesting are only set by databases that carry them.
+	Military    bool `csv:"-" json:"military"`
+	Interesting bool `csv:"-" json:"interesting"`
 }
 
-var db *memdb.MemDB
+var (
+	db     *memdb.MemDB
+	dbLock sync.RWMutex
 
-func downloadFile(filepath string, url string) (err error) {
-	fmt.Println("downloading file")
-	// Create the file
-	out, err := os.Create(filepath)
+	// enrichAircraft adds aircraft database details to the aircraft metrics.
+	enrichAircraft bool
+	// aircraftFilter limits which rows of the aircraft database are loaded.
+	aircraftFilter dbFilter
+
+	// aircraftDbFile is a pre-staged database that is loaded instead of
+	// downloading one, read by aircraftDbLoader or the loader its format
+	// suggests.
+	aircraftDbFile   string
+	aircraftDbLoader aircraftLoader
+	aircraftDbUrl    = "https://opensky-network.org/datasets/metadata/aircraftDatabase.csv"
+	// aircraftDbSha256 is the expected SHA-256 of the database file.
+	aircraftDbSha256 string
+	// aircraftDbReload is how often the database is reloaded, 0 to only
+	// reload on SIGHUP.
+	aircraftDbReload = 24 * time.Hour
+
+	// dbTime is the modification time of the loaded database file.
+	dbTime time.Time
+)
+
+// dbFilter decides which rows of the aircraft database are loaded. A row is
+// loaded if it matches any of the rules, or always when there are none.
+type dbFilter struct {
+	registrationPrefixes []string
+	icaoRanges           []icaoRange
+	countries            []string
+}
+
+// newDbFilter builds a filter from comma separated flag values. Hex ranges
+// are written as start-end, e.g. c00000-c3ffff.
+func newDbFilter(prefixes string, ranges string, countries string) (dbFilter, error) {
+	var filter dbFilter
+
+	for _, prefix := range splitList(prefixes) {
+		filter.registrationPrefixes = append(filter.registrationPrefixes, strings.ToUpper(prefix))
+	}
+	for _, country := range splitList(countries) {
+		filter.countries = append(filter.countries, strings.ToLower(country))
+	}
+	for _, r := range splitList(ranges) {
+		bounds := strings.SplitN(r, "-", 2)
+		start, ok := parseIcao(bounds[0])
+		end := start
+		if ok && len(bounds) == 2 {
+			end, ok = parseIcao(bounds[1])
+		}
+		if !ok || end < start {
+			return dbFilter{}, fmt.Errorf("invalid icao range %q", r)
+		}
+		filter.icaoRanges = append(filter.icaoRanges, icaoRange{start: start, end: end})
+	}
+
+	return filter, nil
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func (f dbFilter) match(a *AircraftDetails) bool {
+	if len(f.registrationPrefixes) == 0 && len(f.icaoRanges) == 0 && len(f.countries) == 0 {
+		return true
+	}
+
+	registration := strings.ToUpper(a.Registration)
+	for _, prefix := range f.registrationPrefixes {
+		if registration != "" && strings.HasPrefix(registration, prefix) {
+			return true
+		}
+	}
+	if country, ok := registrationCountry(a.Registration); ok {
+		for _, c := range f.countries {
+			if strings.ToLower(country) == c {
+				return true
+			}
+		}
+	}
+
+	addr, ok := parseIcao(a.Icao24)
+	if !ok {
+		return false
+	}
+	for _, r := range f.icaoRanges {
+		if addr >= r.start && addr <= r.end {
+			return true
+		}
+	}
+
+	return false
+}
+
+// currentDB returns the loaded aircraft database, or nil while it is still
+// being loaded.
+func currentDB() *memdb.MemDB {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+	return db
+}
+
+// setDB swaps in a new database. Cached misses are dropped since the new
+// database may know those aircraft.
+func setDB(newDB *memdb.MemDB) {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	db = newDB
+	dbMisses.reset()
+}
+
+func setDBTime(modTime time.Time) {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	dbTime = modTime
+}
+
+// dbAge returns the age in seconds of the loaded database file, or 0 until
+// one is loaded.
+func dbAge() float64 {
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+	if dbTime.IsZero() {
+		return 0
+	}
+	return time.Since(dbTime).Seconds()
+}
+
+// downloadFile downloads url into a temporary file next to filepath and
+// renames it into place once complete, so a failed or interrupted download
+// never replaces a good database. When sha256sum is set the download is
+// only kept if it matches.
+func downloadFile(filepath string, url string, sha256sum string) (err error) {
+	log.Info().Str("url", url).Msg("Downloading aircraft database")
+
+	out, err := os.CreateTemp(path.Dir(filepath), path.Base(filepath)+".*.tmp")
 	if err != nil {
-		// log.Fatal("failed to create file")
 		return err
 	}
-	defer out.Close()
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(out.Name())
+		}
+	}()
 
-	// Get the data
 	resp, err := http.Get(url)
 	if err != nil {
-		// log.Fatal("error in http get file")
 		return err
 	}
 	defer resp.Body.Close()
 
-	// Writer the body to file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		// log.Fatal("error in writing file to disk")
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned %s", resp.Status)
+	}
+
+	if _, err = io.Copy(out, resp.Body); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = verifyFile(out.Name(), sha256sum); err != nil {
 		return err
 	}
 
-	return nil
+	return os.Rename(out.Name(), filepath)
 }
 
-func parseCsv(path string, db *memdb.MemDB) {
+// verifyFile checks the SHA-256 of the file at path against the expected hex
+// digest. An empty digest skips the check.
+func verifyFile(path string, sha256sum string) error {
+	if sha256sum == "" {
+		return nil
+	}
 
-	aircraftFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, os.ModePerm)
+	f, err := os.Open(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer aircraftFile.Close()
-
-	txn := db.Txn(true)
+	defer f.Close()
 
-	aircraft := []*AircraftDetails{}
-
-	fmt.Println(path)
-	if err := gocsv.UnmarshalFile(aircraftFile, &aircraft); err != nil { // Load clients from file
-		panic(err)
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, sha256sum) {
+		return fmt.Errorf("sha256 of %s is %s, want %s", path, sum, sha256sum)
 	}
-	// aircraft2 := []*AircraftDetails{
-	// 	{"391927", "Piper", "foo", "bar", "banana"},
-	// 	{"3d3191", "G-115", "foo", "bar", "banana"},
-	// }
-	// for _, a := range aircraft2 {
-	// 	if err := txn.Insert("aircraft", a); err != nil {
-	// 		panic(err)
-	// 	}
-	// }
-
-	for _, aircraft := range aircraft {
-		if aircraft.Icao24 != "" {
-			// fmt.Println("Hello", aircraft.Icao24)
-			// fmt.Println("Icao", aircraft.Icao24)
-			// fmt.Println("Model", aircraft.Model)
-			// fmt.Println("ManufacturerIcao", aircraft.ManufacturerIcao)
-			// fmt.Println("ManufacturerName", aircraft.ManufacturerName)
-			// if aircraft.Status != "" {
-			// 	fmt.Println("Status", aircraft.Status)
-			// }
-
-			if aircraft.Registration != "" {
-				if aircraft.Registration[0:1] == "C" {
-					// fmt.Println(firstCharacter)
-					if err := txn.Insert("aircraft", aircraft); err != nil {
-						panic(err)
-					}
-				}
+	return nil
+}
 
-			}
+// openDbFile opens the aircraft database, transparently decompressing it if
+// it is gzipped.
+func openDbFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
 
-		}
+	r := bufio.NewReader(f)
+	magic, _ := r.Peek(2)
+	if !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{r, f}, nil
+	}
 
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		f.Close()
+		return nil, err
 	}
-	txn.Commit()
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
 
-	// txn = db.Txn(false)
-	// defer txn.Abort()
+// loadAircraftDb fills db with the aircraft loader reads from path that pass
+// filter.
+func loadAircraftDb(path string, loader aircraftLoader, db *memdb.MemDB, filter dbFilter) error {
 
-	// raw, err2 := txn.First("aircraft", "id", "c04101")
-	// if err2 != nil {
-	// 	panic(err2)
-	// }
+	txn := db.Txn(true)
+	defer txn.Abort()
 
-	// // Say hi!
-	// fmt.Printf("Hello %s!\n", raw.(*AircraftDetails))
+	var loaded, filtered, invalid float64
+	err := loader.load(path, func(aircraft *AircraftDetails) error {
+		if aircraft.Icao24 == "" {
+			invalid++
+			return nil
+		}
+		if !filter.match(aircraft) {
+			filtered++
+			return nil
+		}
+		loaded++
+		return txn.Insert("aircraft", aircraft)
+	})
+	if err != nil {
+		return err
+	}
+	opsMetrics.AircraftDbRows(dbRowLabels{Result: "loaded"}).Set(loaded)
+	opsMetrics.AircraftDbRows(dbRowLabels{Result: "filtered"}).Set(filtered)
+	opsMetrics.AircraftDbRows(dbRowLabels{Result: "invalid"}).Set(invalid)
+	txn.Commit()
 
+	return nil
 }
 
 func dbSetup() *memdb.MemDB {
@@ -131,13 +312,13 @@ func dbSetup() *memdb.MemDB {
 						Name:         "registration",
 						Unique:       false,
 						AllowMissing: true,
-						Indexer:      &memdb.StringFieldIndex{Field: "Registration"},
+						Indexer:      &memdb.StringFieldIndex{Field: "Registration", Lowercase: true},
 					},
 					"manufacturerIcao": {
 						Name:         "manufacturerIcao",
 						Unique:       false,
 						AllowMissing: true,
-						Indexer:      &memdb.StringFieldIndex{Field: "ManufacturerIcao"},
+						Indexer:      &memdb.StringFieldIndex{Field: "ManufacturerIcao", Lowercase: true},
 					},
 					"manufacturerName": {
 						Name:         "manufacturerName",
@@ -149,7 +330,7 @@ func dbSetup() *memdb.MemDB {
 						Name:         "model",
 						Unique:       false,
 						AllowMissing: true,
-						Indexer:      &memdb.StringFieldIndex{Field: "Model"},
+						Indexer:      &memdb.StringFieldIndex{Field: "Model", Lowercase: true},
 					},
 				},
 			},
@@ -164,47 +345,213 @@ func dbSetup() *memdb.MemDB {
 	return db
 }
 
-func FindAircraft(icao string) *AircraftDetails {
-	// fmt.Println(db)
-	txn := db.Txn(false)
+// errNoAircraftDb is returned by lookups while the database is still loading.
+var errNoAircraftDb = errors.New("aircraft database not loaded")
+
+// FindAircraft looks up an aircraft by ICAO24 hex address. found is false
+// when the database has no such aircraft.
+func FindAircraft(icao string) (details *AircraftDetails, found bool, err error) {
+	icao = strings.ToLower(icao)
+
+	aircraftDb := currentDB()
+	if aircraftDb == nil {
+		opsMetrics.AircraftDbLookups(dbLookupLabels{Result: "error", Cached: "false"}).Inc()
+		return nil, false, errNoAircraftDb
+	}
+
+	// Aircraft missing from the database tend to stay in view for a while,
+	// so remember them rather than searching again on every read.
+	if dbMisses.contains(icao, time.Now()) {
+		opsMetrics.AircraftDbLookups(dbLookupLabels{Result: "miss", Cached: "true"}).Inc()
+		return nil, false, nil
+	}
+
+	txn := aircraftDb.Txn(false)
 	defer txn.Abort()
 
 	raw, err := txn.First("aircraft", "id", icao)
 	if err != nil {
-		panic("Error in db lookup")
+		opsMetrics.AircraftDbLookups(dbLookupLabels{Result: "error", Cached: "false"}).Inc()
+		return nil, false, err
+	}
+	details, ok := raw.(*AircraftDetails)
+	if !ok || details == nil {
+		dbMisses.add(icao, time.Now())
+		opsMetrics.AircraftDbLookups(dbLookupLabels{Result: "miss", Cached: "false"}).Inc()
+		return nil, false, nil
+	}
+
+	opsMetrics.AircraftDbLookups(dbLookupLabels{Result: "hit", Cached: "false"}).Inc()
+	return details, true, nil
+}
+
+// missCache remembers addresses that are not in the aircraft database.
+type missCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]time.Time
+}
+
+var dbMisses = &missCache{ttl: time.Hour, size: 10000}
+
+func (c *missCache) contains(icao string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires, ok := c.entries[icao]
+	if ok && now.After(expires) {
+		delete(c.entries, icao)
+		return false
+	}
+	return ok
+}
+
+func (c *missCache) add(icao string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Start over rather than track ages once full; misses are cheap to
+	// find again.
+	if c.entries == nil || len(c.entries) >= c.size {
+		c.entries = make(map[string]time.Time)
+	}
+	c.entries[icao] = now.Add(c.ttl)
+}
+
+func (c *missCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+}
+
+// enrichMetrics exposes the aircraft database details of every aircraft in
+// the database as an info metric that can be joined on hex.
+func enrichMetrics(aircraft []Aircraft) {
+
+	dump1090AircraftInfo.Reset()
+
+	if !enrichAircraft || currentDB() == nil {
+		return
+	}
+
+	for _, a := range aircraft {
+		// Non-ICAO addresses are not in the database.
+		if strings.HasPrefix(a.Hex, "~") {
+			continue
+		}
+		details, found, err := FindAircraft(a.Hex)
+		if err != nil {
+			log.Error().Err(err).Str("hex", a.Hex).Msg("Error looking up aircraft")
+			continue
+		}
+		if !found {
+			continue
+		}
+		dump1090AircraftInfo.With(prometheus.Labels{
+			"hex":          a.Hex,
+			"registration": details.Registration,
+			"model":        details.Model,
+			"type_code":    details.Typecode,
+			"manufacturer": details.ManufacturerName,
+			"operator":     details.Operator,
+			"military":     strconv.FormatBool(details.Military),
+			"interesting":  strconv.FormatBool(details.Interesting),
+		}).Set(1)
 	}
-	// fmt.Printf("Hello %s!\n", raw.(*AircraftDetails))
-	return raw.(*AircraftDetails)
 }
 
+// flightInit loads the aircraft database and keeps it fresh, reloading it
+// every aircraftDbReload and whenever the process gets a SIGHUP.
 func flightInit() {
-	var aircraftDbUrl string = "https://opensky-network.org/datasets/metadata/aircraftDatabase.csv"
-	// var zipFilePath string = "/home/melvin/Projects/go_dump1090_metrics/aircraftDatabase.zip"
-	var csvFilePath string = "./aircraftDatabase.csv"
-
-	// Setup BadgerDB
-	// opt := badger.DefaultOptions("").WithInMemory(true)
-	// db, err := badger.Open(opt)
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	// defer db.Close()
-
-	// fmt.Println(aircraftDbUrl)
-	info, err := os.Stat(csvFilePath)
-	if err != nil {
-		// TODO: handle errors (e.g. file not found)
-		if errors.Is(err, os.ErrNotExist) {
-			fmt.Println("doesnt exist")
-			downloadFile(csvFilePath, aircraftDbUrl)
+	// Catch SIGHUP before the first load, which can be a long download, so
+	// one sent meanwhile does not kill the exporter.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	reloadDBLogged()
+
+	var tick <-chan time.Time
+	if aircraftDbReload > 0 {
+		ticker := time.NewTicker(aircraftDbReload)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	reloadLoop(hup, tick)
+}
+
+// reloadLoop reloads the database on every signal from hup and every tick
+// until hup is closed.
+func reloadLoop(hup <-chan os.Signal, tick <-chan time.Time) {
+	for {
+		select {
+		case _, ok := <-hup:
+			if !ok {
+				return
+			}
+			log.Info().Msg("Reloading aircraft database on SIGHUP")
+		case <-tick:
 		}
-	} else {
-		if info.ModTime().Before(time.Now().AddDate(0, 0, -7)) {
-			downloadFile(csvFilePath, aircraftDbUrl)
+		reloadDBLogged()
+	}
+}
+
+func reloadDBLogged() {
+	if err := reloadDB(); err != nil {
+		opsMetrics.AircraftDbReloadFailures().Inc()
+		log.Error().Err(err).Msg("Error loading aircraft database")
+		return
+	}
+	opsMetrics.AircraftDbReloadTime().SetToCurrentTime()
+}
+
+// reloadDB builds a new database and swaps it in once it is fully loaded, so
+// lookups keep using the old one until then and a failed load leaves it in
+// place.
+func reloadDB() error {
+	dbPath := aircraftDbFile
+
+	if dbPath == "" {
+		// Refresh the downloaded copy once a week. If the download fails a
+		// stale copy is still better than no database. A pinned checksum
+		// names one exact file, which a refresh would never match, so with
+		// one the copy is only downloaded until it matches.
+		dbPath = "./aircraftDatabase.csv"
+		info, err := os.Stat(dbPath)
+		stale := err != nil || info.ModTime().Before(time.Now().AddDate(0, 0, -7))
+		if aircraftDbSha256 != "" {
+			stale = verifyFile(dbPath, aircraftDbSha256) != nil
 		}
+		if stale {
+			if err := downloadFile(dbPath, aircraftDbUrl, aircraftDbSha256); err != nil {
+				log.Error().Err(err).Str("url", aircraftDbUrl).Msg("Error downloading aircraft database")
+			}
+		}
+	}
+	if err := verifyFile(dbPath, aircraftDbSha256); err != nil {
+		return err
 	}
 
-	db = dbSetup()
-	parseCsv(csvFilePath, db)
+	info, err := os.Stat(dbPath)
+	if err != nil {
+		return err
+	}
+
+	loader := aircraftDbLoader
+	if loader == nil {
+		if loader, err = detectLoader(dbPath); err != nil {
+			return err
+		}
+	}
+
+	aircraftDb := dbSetup()
+	if err := loadAircraftDb(dbPath, loader, aircraftDb, aircraftFilter); err != nil {
+		return fmt.Errorf("%s: %w", dbPath, err)
+	}
+	setDB(aircraftDb)
+	setDBTime(info.ModTime())
+	log.Info().Str("path", dbPath).Time("modified", info.ModTime()).Msg("Loaded aircraft database")
 
+	return nil
 }
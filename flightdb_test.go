@@ -0,0 +1,410 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-memdb"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testAircraftCsv = `"icao24","registration","manufacturericao","manufacturername","model","typecode","operator"
+"c05f0a","C-FABC","BOEING","Boeing","737-8CT","B738","WestJet"
+"a0b1c2","N12345","CESSNA","Cessna","172S","C172",""
+`
+
+func writeTestCsv(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "aircraftDatabase.csv")
+	if err := os.WriteFile(path, []byte(testAircraftCsv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEnrichMetrics(t *testing.T) {
+	aircraftDb := dbSetup()
+	if err := loadAircraftDb(writeTestCsv(t), openskyLoader{}, aircraftDb, dbFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	setDB(aircraftDb)
+	enrichAircraft = true
+	defer func() {
+		setDB(nil)
+		enrichAircraft = false
+	}()
+
+	enrichMetrics([]Aircraft{{Hex: "c05f0a"}, {Hex: "c0ffee"}, {Hex: "~123456"}})
+
+	if got := testutil.CollectAndCount(dump1090AircraftInfo); got != 1 {
+		t.Fatalf("got %d info series, want 1", got)
+	}
+	info := dump1090AircraftInfo.WithLabelValues("c05f0a", "C-FABC", "737-8CT", "B738", "Boeing", "WestJet", "false", "false")
+	if got := testutil.ToFloat64(info); got != 1 {
+		t.Errorf("got %f, want 1", got)
+	}
+}
+
+func TestDbFilter(t *testing.T) {
+	var tests = []struct {
+		name       string
+		prefixes   string
+		ranges     string
+		countries  string
+		wantLoaded float64
+	}{
+		{"all", "", "", "", 2},
+		{"registration prefix", "c-", "", "", 1},
+		{"icao range", "", "a00000-afffff", "", 1},
+		{"country", "", "", "canada, united states", 2},
+		{"any rule", "N", "c00000-c3ffff", "", 2},
+		{"no match", "G-", "e00000-efffff", "Germany", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newDbFilter(tt.prefixes, tt.ranges, tt.countries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := loadAircraftDb(writeTestCsv(t), openskyLoader{}, dbSetup(), filter); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded := opsMetrics.AircraftDbRows(dbRowLabels{Result: "loaded"})
+			if got := testutil.ToFloat64(loaded); got != tt.wantLoaded {
+				t.Errorf("got %f loaded rows, want %f", got, tt.wantLoaded)
+			}
+			filtered := opsMetrics.AircraftDbRows(dbRowLabels{Result: "filtered"})
+			if got := testutil.ToFloat64(filtered); got != 2-tt.wantLoaded {
+				t.Errorf("got %f filtered rows, want %f", got, 2-tt.wantLoaded)
+			}
+		})
+	}
+
+	if _, err := newDbFilter("", "c3ffff-c00000", ""); err == nil {
+		t.Error("expected an error for a reversed range")
+	}
+}
+
+func TestParseCsvGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aircraftDatabase.csv.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte(testAircraftCsv))
+	gz.Close()
+	f.Close()
+
+	aircraftDb := dbSetup()
+	if err := loadAircraftDb(path, openskyLoader{}, aircraftDb, dbFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := aircraftDb.Txn(false).First("aircraft", "id", "a0b1c2")
+	if err != nil || raw == nil {
+		t.Fatalf("aircraft not loaded from gzipped database: %v", err)
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	sum := sha256.Sum256([]byte(testAircraftCsv))
+	goodSum := hex.EncodeToString(sum[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testAircraftCsv))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "aircraftDatabase.csv")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failed downloads and checksum mismatches leave the old file alone.
+	if err := downloadFile(path, server.URL+"/missing", ""); err == nil {
+		t.Error("expected an error for a failed download")
+	}
+	if err := downloadFile(path, server.URL, "00"+goodSum[2:]); err == nil {
+		t.Error("expected an error for a checksum mismatch")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old" {
+		t.Errorf("database replaced by a failed download: %q", data)
+	}
+
+	if err := downloadFile(path, server.URL, goodSum); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != testAircraftCsv {
+		t.Errorf("got %q after download", data)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestAircraftLoaders(t *testing.T) {
+	var tests = []struct {
+		path   string
+		loader aircraftLoader
+		rows   float64
+		hex    string
+		want   AircraftDetails
+	}{
+		{
+			path:   "testdata/mictronics",
+			loader: mictronicsLoader{},
+			rows:   4,
+			hex:    "aee5ab",
+			want:   AircraftDetails{Icao24: "aee5ab", Model: "LOCKHEED C-130 Hercules", Typecode: "C130", Military: true},
+		},
+		{
+			// C0.js holds array entries, as current tar1090 databases do.
+			path:   "testdata/mictronics",
+			loader: mictronicsLoader{},
+			rows:   4,
+			hex:    "c0ffee",
+			want:   AircraftDetails{Icao24: "c0ffee", Registration: "C-GXYZ", Model: "DE HAVILLAND DHC-8-400", Typecode: "DH8D", Interesting: true},
+		},
+		{
+			path:   "testdata/BaseStation.sqb",
+			loader: basestationLoader{},
+			rows:   201,
+			hex:    "c000c7",
+			want:   AircraftDetails{Icao24: "c000c7", Registration: "C-G199", ManufacturerName: "CESSNA", Model: "172S", Typecode: "C172", Operator: "Owner 199"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			loader, err := detectLoader(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loader != tt.loader {
+				t.Errorf("detected %T, want %T", loader, tt.loader)
+			}
+
+			aircraftDb := dbSetup()
+			if err := loadAircraftDb(tt.path, loader, aircraftDb, dbFilter{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := testutil.ToFloat64(opsMetrics.AircraftDbRows(dbRowLabels{Result: "loaded"})); got != tt.rows {
+				t.Errorf("got %f rows, want %f", got, tt.rows)
+			}
+
+			raw, err := aircraftDb.Txn(false).First("aircraft", "id", tt.hex)
+			if err != nil || raw == nil {
+				t.Fatalf("%s not loaded: %v", tt.hex, err)
+			}
+			if got := *raw.(*AircraftDetails); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBasestationOverflow(t *testing.T) {
+	aircraftDb := dbSetup()
+	if err := loadAircraftDb("testdata/BaseStation.sqb", basestationLoader{}, aircraftDb, dbFilter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := aircraftDb.Txn(false).First("aircraft", "id", "c05f0a")
+	if err != nil || raw == nil {
+		t.Fatalf("c05f0a not loaded: %v", err)
+	}
+	a := raw.(*AircraftDetails)
+	if len(a.Operator) != len("WestJet ")+1500 || !a.Interesting || a.Registration != "C-FABC" {
+		t.Errorf("unexpected aircraft %s %s interesting=%t operator of %d bytes", a.Icao24, a.Registration, a.Interesting, len(a.Operator))
+	}
+}
+
+func TestBasestationCorrupt(t *testing.T) {
+	data, err := os.ReadFile("testdata/BaseStation.sqb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Page 2 onwards have their b-tree header at the start of the page.
+	interior := 0
+	for n := 2; n*512 <= len(data); n++ {
+		if data[(n-1)*512] == 0x05 {
+			interior = n
+			break
+		}
+	}
+	if interior == 0 {
+		t.Fatal("no interior page in testdata/BaseStation.sqb")
+	}
+	start := (interior - 1) * 512
+
+	var tests = []struct {
+		name    string
+		corrupt func([]byte)
+	}{
+		{"cell count past the page", func(b []byte) { binary.BigEndian.PutUint16(b[start+3:], 0xffff) }},
+		{"page pointing at itself", func(b []byte) { binary.BigEndian.PutUint32(b[start+8:], uint32(interior)) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupt := append([]byte(nil), data...)
+			tt.corrupt(corrupt)
+			path := filepath.Join(t.TempDir(), "BaseStation.sqb")
+			if err := os.WriteFile(path, corrupt, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadAircraftDb(path, basestationLoader{}, dbSetup(), dbFilter{}); err == nil {
+				t.Error("expected an error for a corrupt database")
+			}
+		})
+	}
+}
+
+func TestReloadDB(t *testing.T) {
+	aircraftDbFile = writeTestCsv(t)
+	defer func() {
+		aircraftDbFile = ""
+		setDB(nil)
+	}()
+
+	if err := reloadDB(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := currentDB()
+	if loaded == nil {
+		t.Fatal("no database after reload")
+	}
+
+	// A failed reload keeps serving the previous database.
+	aircraftDbFile = filepath.Join(t.TempDir(), "missing.csv")
+	if err := reloadDB(); err == nil {
+		t.Error("expected an error reloading a missing database")
+	}
+	if currentDB() != loaded {
+		t.Error("failed reload replaced the database")
+	}
+}
+
+func TestReloadLoop(t *testing.T) {
+	aircraftDbFile = writeTestCsv(t)
+	defer func() {
+		aircraftDbFile = ""
+		setDB(nil)
+	}()
+
+	// waitReload waits for the loop to swap in a database other than prev.
+	waitReload := func(prev *memdb.MemDB) *memdb.MemDB {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if db := currentDB(); db != nil && db != prev {
+				return db
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("database was not reloaded")
+		return nil
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	tick := make(chan time.Time)
+	done := make(chan struct{})
+	go func() {
+		reloadLoop(hup, tick)
+		close(done)
+	}()
+
+	// A SIGHUP reloads the database instead of killing the process.
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	loaded := waitReload(nil)
+
+	tick <- time.Now()
+	waitReload(loaded)
+
+	signal.Stop(hup)
+	close(hup)
+	<-done
+}
+
+func TestRegistrationCountry(t *testing.T) {
+	var tests = []struct {
+		registration string
+		country      string
+	}{
+		{"C-FABC", "Canada"},
+		{"N12345", "United States"},
+		{"g-euua", "United Kingdom"},
+		{"B-HNR", "Hong Kong"},
+		{"B-1234", "China"},
+		{"JA801A", "Japan"},
+		{"9XR-WP", "Rwanda"},
+		{"", ""},
+		{"QQ-ABC", ""},
+	}
+
+	for _, tt := range tests {
+		if got, _ := registrationCountry(tt.registration); got != tt.country {
+			t.Errorf("registrationCountry(%q) = %q, want %q", tt.registration, got, tt.country)
+		}
+	}
+}
+
+func TestFindAircraft(t *testing.T) {
+	setDB(nil)
+	if _, _, err := FindAircraft("c05f0a"); err != errNoAircraftDb {
+		t.Errorf("got error %v before the database is loaded", err)
+	}
+
+	aircraftDb := dbSetup()
+	if err := loadAircraftDb(writeTestCsv(t), openskyLoader{}, aircraftDb, dbFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	setDB(aircraftDb)
+	defer setDB(nil)
+
+	details, found, err := FindAircraft("C05F0A")
+	if err != nil || !found || details.Registration != "C-FABC" {
+		t.Errorf("got %+v, %t, %v", details, found, err)
+	}
+
+	cachedMisses := opsMetrics.AircraftDbLookups(dbLookupLabels{Result: "miss", Cached: "true"})
+	before := testutil.ToFloat64(cachedMisses)
+	for i := 0; i < 2; i++ {
+		if details, found, err := FindAircraft("c0ffee"); err != nil || found || details != nil {
+			t.Errorf("got %+v, %t, %v for an unknown aircraft", details, found, err)
+		}
+	}
+	if got := testutil.ToFloat64(cachedMisses) - before; got != 1 {
+		t.Errorf("got %f cached misses, want 1", got)
+	}
+
+	// A new database forgets the misses of the old one.
+	setDB(aircraftDb)
+	FindAircraft("c0ffee")
+	if got := testutil.ToFloat64(cachedMisses) - before; got != 1 {
+		t.Errorf("got %f cached misses after swapping the database, want 1", got)
+	}
+}
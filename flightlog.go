@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// flights is the log of completed visits, nil unless -flight-log is set.
+var flights *flightLog
+
+var errNoFlightLog = errors.New("flight log is not enabled")
+
+// flight is one completed visit as stored in the flight log.
+type flight struct {
+	session
+	Registration string `json:"registration,omitempty"`
+}
+
+// newFlight adds what the aircraft database knows about the aircraft to a
+// visit that ended.
+func newFlight(s session) flight {
+	f := flight{session: s}
+	if details, found, _ := FindAircraft(s.Hex); found {
+		f.Registration = details.Registration
+	}
+	return f
+}
+
+// flightLog keeps completed visits in a file of json lines, one flight per
+// line in the order they ended. New flights are appended, and the file is
+// rewritten without the flights that aged out once they make up half of it.
+type flightLog struct {
+	mu      sync.Mutex
+	path    string
+	flights []flight
+	// stale counts the lines in the file for flights no longer kept.
+	stale int
+
+	// retention is how long a flight is kept after it ended, and
+	// maxFlights how many flights are kept at most. Zero keeps everything.
+	retention  time.Duration
+	maxFlights int
+}
+
+// openFlightLog loads the flights kept at path. A missing file starts an
+// empty log.
+func openFlightLog(path string, retention time.Duration, maxFlights int, now time.Time) (*flightLog, error) {
+	l := &flightLog{path: path, retention: retention, maxFlights: maxFlights}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var f flight
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			// A crash mid-append can leave a partial last line.
+			log.Warn().Err(err).Str("path", path).Msg("Skipping invalid flight log line")
+			l.stale++
+			continue
+		}
+		l.flights = append(l.flights, f)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire(now)
+	return l, l.compact()
+}
+
+// add appends a completed visit to the log.
+func (l *flightLog) add(f flight) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.flights = append(l.flights, f)
+	if err := l.append(f); err != nil {
+		opsMetrics.FlightLogErrors().Inc()
+		log.Error().Err(err).Str("path", l.path).Msg("Error writing flight log")
+	}
+
+	l.expire(f.LastSeen)
+	if l.stale > 0 && l.stale >= len(l.flights) {
+		if err := l.compact(); err != nil {
+			opsMetrics.FlightLogErrors().Inc()
+			log.Error().Err(err).Str("path", l.path).Msg("Error compacting flight log")
+		}
+	}
+}
+
+func (l *flightLog) append(f flight) error {
+	line, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// expire drops the flights past the retention limits, oldest first.
+func (l *flightLog) expire(now time.Time) {
+	drop := 0
+	if l.maxFlights > 0 && len(l.flights) > l.maxFlights {
+		drop = len(l.flights) - l.maxFlights
+	}
+	if l.retention > 0 {
+		for drop < len(l.flights) && now.Sub(l.flights[drop].LastSeen) > l.retention {
+			drop++
+		}
+	}
+
+	if drop > 0 {
+		l.flights = append([]flight(nil), l.flights[drop:]...)
+		l.stale += drop
+	}
+}
+
+// compact rewrites the file with only the flights kept.
+func (l *flightLog) compact() error {
+	if l.stale == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, f := range l.flights {
+		if err := encoder.Encode(f); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(l.path, buf.Bytes()); err != nil {
+		return err
+	}
+	l.stale = 0
+	return nil
+}
+
+// flightQuery selects flights from the log. Empty fields match every flight.
+type flightQuery struct {
+	since        time.Time
+	until        time.Time
+	hex          string
+	callsign     string
+	registration string
+	limit        int
+}
+
+func (q flightQuery) match(f flight) bool {
+	if !q.since.IsZero() && f.LastSeen.Before(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && f.FirstSeen.After(q.until) {
+		return false
+	}
+	if q.hex != "" && !strings.EqualFold(f.Hex, q.hex) {
+		return false
+	}
+	if q.callsign != "" && !strings.EqualFold(f.Flight, q.callsign) {
+		return false
+	}
+	if q.registration != "" && !strings.EqualFold(normalizeRegistration(f.Registration), normalizeRegistration(q.registration)) {
+		return false
+	}
+	return true
+}
+
+// normalizeRegistration drops the dash so C-GXYZ and CGXYZ match.
+func normalizeRegistration(registration string) string {
+	return strings.ReplaceAll(registration, "-", "")
+}
+
+// query returns the flights matching q, the most recent first.
+func (l *flightLog) query(q flightQuery) []flight {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := []flight{}
+	for i := len(l.flights) - 1; i >= 0; i-- {
+		if q.limit > 0 && len(result) >= q.limit {
+			break
+		}
+		if q.match(l.flights[i]) {
+			result = append(result, l.flights[i])
+		}
+	}
+	// Flights are logged when they end, so sort the ones that overlapped.
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].LastSeen.After(result[j].LastSeen)
+	})
+	return result
+}
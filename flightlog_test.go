@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testFlight(hex, callsign, registration string, lastSeen time.Time) flight {
+	return flight{
+		session:      session{Hex: hex, Flight: callsign, FirstSeen: lastSeen.Add(-10 * time.Minute), LastSeen: lastSeen},
+		Registration: registration,
+	}
+}
+
+func TestFlightLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flights.jsonl")
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	l, err := openFlightLog(path, 24*time.Hour, 3, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.add(testFlight("c05f0a", "WJA123", "C-GXYZ", now.Add(-30*time.Hour)))
+	l.add(testFlight("c0ffee", "ACA456", "", now.Add(-2*time.Hour)))
+	l.add(testFlight("c05f0a", "WJA124", "C-GXYZ", now.Add(-time.Hour)))
+	l.add(testFlight("a0b1c2", "", "N12345", now))
+
+	// The first flight aged out and the log holds at most 3.
+	got := l.query(flightQuery{})
+	if len(got) != 3 || got[0].Hex != "a0b1c2" || got[2].Hex != "c0ffee" {
+		t.Fatalf("got flights %+v", got)
+	}
+
+	reopened, err := openFlightLog(path, 24*time.Hour, 3, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.query(flightQuery{}); len(got) != 3 {
+		t.Errorf("got %d flights after reopening, want 3", len(got))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("got %d lines after compacting, want 3", lines)
+	}
+
+	var tests = []struct {
+		name  string
+		query flightQuery
+		want  int
+	}{
+		{"hex", flightQuery{hex: "C05F0A"}, 1},
+		{"callsign", flightQuery{callsign: "aca456"}, 1},
+		{"registration", flightQuery{registration: "cgxyz"}, 1},
+		{"since", flightQuery{since: now.Add(-90 * time.Minute)}, 2},
+		{"until", flightQuery{until: now.Add(-90 * time.Minute)}, 1},
+		{"limit", flightQuery{limit: 2}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reopened.query(tt.query); len(got) != tt.want {
+				t.Errorf("got %d flights, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestFlightLogPartialLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flights.jsonl")
+	line, _ := json.Marshal(testFlight("c05f0a", "WJA123", "", time.Now()))
+	if err := os.WriteFile(path, append(line, []byte("\n{\"hex\":\"c0f")...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := openFlightLog(path, 0, 0, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := l.query(flightQuery{}); len(got) != 1 {
+		t.Errorf("got %d flights, want 1", len(got))
+	}
+}
+
+func TestSessionFlight(t *testing.T) {
+	ReceiverLat, ReceiverLon = 51, -114
+	defer func() { ReceiverLat, ReceiverLon = 0, 0 }()
+
+	tracker := newSessionTracker()
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, a := range []Aircraft{
+		// North of the receiver, then the same position again, then east.
+		{Hex: "c05f0a", Latitude: 51.5, Longitude: -114},
+		{Hex: "c05f0a", Latitude: 51.5, Longitude: -114},
+		{Hex: "c05f0a", Latitude: 51, Longitude: -113.5},
+	} {
+		tracker.observe([]Aircraft{a}, now.Add(time.Duration(i)*time.Second))
+	}
+	_, ended := tracker.observe(nil, now.Add(time.Hour))
+	if len(ended) != 1 {
+		t.Fatalf("got %d ended visits, want 1", len(ended))
+	}
+
+	s := ended[0]
+	if s.Positions != 2 {
+		t.Errorf("got %d positions, want 2", s.Positions)
+	}
+	if s.EntryBearing == nil || *s.EntryBearing != 0 {
+		t.Errorf("got entry bearing %v, want 0", s.EntryBearing)
+	}
+	if s.ExitBearing == nil || *s.ExitBearing < 89 || *s.ExitBearing > 90 {
+		t.Errorf("got exit bearing %v, want about 90", s.ExitBearing)
+	}
+}
+
+func TestApiFlights(t *testing.T) {
+	mux := http.NewServeMux()
+	registerApi(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/flights", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d without a flight log, want 503", rec.Code)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	l, err := openFlightLog(filepath.Join(t.TempDir(), "flights.jsonl"), 0, 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.add(testFlight("c05f0a", "WJA123", "C-GXYZ", now.Add(-time.Hour)))
+	l.add(testFlight("c0ffee", "ACA456", "", now))
+	flights = l
+	defer func() { flights = nil }()
+
+	var tests = []struct {
+		path   string
+		status int
+		count  int
+	}{
+		{"/api/flights", http.StatusOK, 2},
+		{"/api/flights?hex=c05f0a", http.StatusOK, 1},
+		{"/api/flights?callsign=ACA456", http.StatusOK, 1},
+		{"/api/flights?since=" + now.Add(-time.Minute).Format(time.RFC3339), http.StatusOK, 1},
+		{"/api/flights?since=yesterday", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var got []flight
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.count {
+				t.Errorf("got %d flights, want %d", len(got), tt.count)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestIcaoCountry(t *testing.T) {
+	var tests = []struct {
+		hex     string
+		country string
+	}{
+		{"c05f0a", "Canada"},
+		{"A0B1C2", "United States"},
+		{"789123", "Hong Kong"},
+		{"780123", "China"},
+		{"", ""},
+		{"~12345", ""},
+	}
+
+	for _, tt := range tests {
+		if got, _ := icaoCountry(tt.hex); got != tt.country {
+			t.Errorf("icaoCountry(%q) = %q, want %q", tt.hex, got, tt.country)
+		}
+	}
+}
+
+func TestIcaoMilitary(t *testing.T) {
+	var tests = []struct {
+		hex      string
+		military bool
+	}{
+		{"ae1234", true},
+		{"a0b1c2", false},
+		{"43c5a1", true},
+		{"406a1b", false},
+		{"~ae1234", false},
+	}
+
+	for _, tt := range tests {
+		if got := icaoMilitary(tt.hex); got != tt.military {
+			t.Errorf("icaoMilitary(%q) = %t, want %t", tt.hex, got, tt.military)
+		}
+	}
+}
+
+func TestCountryMetrics(t *testing.T) {
+	countryMetrics([]Aircraft{{Hex: "c05f0a"}, {Hex: "c2f1a0"}, {Hex: "c0ffee"}, {Hex: "~123456"}})
+
+	var tests = []struct {
+		country  string
+		military string
+		want     float64
+	}{
+		{"Canada", "false", 2},
+		{"Canada", "true", 1},
+		{"unknown", "false", 1},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(dump1090AircraftByCountry.WithLabelValues(tt.country, tt.military)); got != tt.want {
+			t.Errorf("got %f aircraft for %s military=%s, want %f", got, tt.country, tt.military, tt.want)
+		}
+	}
+}
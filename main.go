@@ -5,31 +5,41 @@ import (
 	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/cabify/gotoprom"
+	"github.com/cabify/gotoprom/prometheusvanilla"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 type AircraftList struct {
+	Now      float64    `json:"now,omitempty"`
 	Messages float64    `json:"messages,int"`
 	Aircraft []Aircraft `json:"aircraft"`
 }
 
 type Aircraft struct {
 	Hex         string   `json:"hex"`
+	Type        string   `json:"type,omitempty"`
 	Flight      string   `json:"flight,omitempty"`
-	AltoBaro    uint16   `json:"alt_baro,omitempty"`
-	AltoGeom    uint16   `json:"alt_geom,omitempty"`
+	Squawk      string   `json:"squawk,omitempty"`
+	AltoBaro    Altitude `json:"alt_baro,omitempty"`
+	AltoGeom    Altitude `json:"alt_geom,omitempty"`
 	GroundSpeed float64  `json:"gs,omitempty"`
 	BaroRate    int16    `json:"baro_rate,omitempty"`
 	Latitude    float64  `json:"lat,omitempty"`
@@ -42,7 +52,55 @@ type Aircraft struct {
 	Seen        float64  `json:"seen,omitempty"`
 	SeenPos     float64  `json:"seen_pos,omitempty"`
 	Mlat        []string `json:"mlat,omitempty"`
+	Tisb        []string `json:"tisb,omitempty"`
+	NavModes    []string `json:"nav_modes,omitempty"`
+
+	// dump1090 leaves these out when they are unknown and zero is a valid
+	// value for most of them, so they are pointers to tell the two apart.
+	Track          *float64 `json:"track,omitempty"`
+	IAS            *float64 `json:"ias,omitempty"`
+	TAS            *float64 `json:"tas,omitempty"`
+	Mach           *float64 `json:"mach,omitempty"`
+	NavAltitudeMcp *float64 `json:"nav_altitude_mcp,omitempty"`
+	NavQnh         *float64 `json:"nav_qnh,omitempty"`
+	Version        *float64 `json:"version,omitempty"`
+	Nic            *float64 `json:"nic,omitempty"`
+	NacP           *float64 `json:"nac_p,omitempty"`
+	Sil            *float64 `json:"sil,omitempty"`
+	Gva            *float64 `json:"gva,omitempty"`
+	Sda            *float64 `json:"sda,omitempty"`
+	Alert          *float64 `json:"alert,omitempty"`
+	Spi            *float64 `json:"spi,omitempty"`
 }
+
+// Altitude is an altitude in feet as reported in aircraft.json, where an
+// aircraft on the ground has an alt_baro of "ground" instead of a number.
+type Altitude struct {
+	Feet   int
+	Ground bool
+}
+
+func (a *Altitude) UnmarshalJSON(b []byte) error {
+	if string(b) == `"ground"` {
+		*a = Altitude{Ground: true}
+		return nil
+	}
+
+	var feet float64
+	if err := json.Unmarshal(b, &feet); err != nil {
+		return fmt.Errorf("invalid altitude %s: %w", b, err)
+	}
+	*a = Altitude{Feet: int(feet)}
+	return nil
+}
+
+func (a Altitude) MarshalJSON() ([]byte, error) {
+	if a.Ground {
+		return []byte(`"ground"`), nil
+	}
+	return json.Marshal(a.Feet)
+}
+
 type Coordinate struct {
 	Lat float64 `json:"lat"`
 	Lon float64 `json:"lon"`
@@ -51,12 +109,22 @@ type Coordinate struct {
 var (
 	ReceiverLat float64
 	ReceiverLon float64
+	// ReceiverAlt is the height of the antenna above mean sea level in
+	// metres.
+	ReceiverAlt float64
+	// AntennaHeight is the height of the antenna above the surrounding
+	// ground in metres. Only the radio horizon depends on it: a receiver on
+	// a plateau sees no further than one on the plain below.
+	AntennaHeight float64 = 10
 )
 
 const radius = 6371.0e3
 
+// positionMaxAge is how old in seconds a message or position can be for the
+// aircraft metrics, zones and visits to still use it.
+const positionMaxAge = 15
+
 var myClient = &http.Client{Timeout: 10 * time.Second}
-var directionLut = [17]string{"N", "NE", "NE", "E", "E", "SE", "SE", "S", "S", "SW", "SW", "W", "W", "NW", "NW", "N"}
 
 func contains(s []string, str string) bool {
 	for _, v := range s {
@@ -68,6 +136,74 @@ func contains(s []string, str string) bool {
 	return false
 }
 
+// writeJsonFile writes v to path as json, replacing it atomically.
+func writeJsonFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces path with data through a temporary file so a crash
+// mid-write never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// saveEvery calls save with path on every tick, for state that is kept
+// across restarts.
+func saveEvery(path string, interval time.Duration, save func(string) error) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		saveState(path, save)
+	}
+}
+
+func saveState(path string, save func(string) error) {
+	if err := save(path); err != nil {
+		log.Error().Err(err).Str("path", path).Msg("Error saving state")
+	}
+}
+
+// shutdownOnSignal calls every stop function before exiting on SIGINT or
+// SIGTERM, so state only saved on a ticker survives a restart.
+func shutdownOnSignal(stops []func()) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-stop
+	log.Info().Str("signal", sig.String()).Msg("Shutting down")
+	for _, fn := range stops {
+		fn()
+	}
+	os.Exit(0)
+}
+
+// isURL reports whether path points at a remote dump1090 rather than a
+// local directory.
+func isURL(path string) bool {
+	u, err := url.ParseRequestURI(path)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// epochAge returns how many seconds ago a unix timestamp from dump1090 was.
+func epochAge(epoch float64) float64 {
+	return float64(time.Now().UnixNano())/1e9 - epoch
+}
+
 func getJson(url string, target interface{}) error {
 	r, err := myClient.Get(url)
 	if err != nil {
@@ -82,23 +218,19 @@ func degrees2radians(degrees float64) float64 {
 	return degrees * math.Pi / 180
 }
 
+// relativeAngle returns the initial great-circle bearing from the first
+// point to the second in degrees clockwise from true north, in [0, 360).
 func relativeAngle(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
 
-	deg := math.Atan((lng2-lng1)/(lat2-lat1)) * (180 / math.Pi)
+	phi1 := degrees2radians(lat1)
+	phi2 := degrees2radians(lat2)
+	deltaLng := degrees2radians(lng2 - lng1)
 
-	if lat2 == lat1 {
-		if lng2 > lng1 {
-			return 90
-		} else {
-			return 270
-		}
-	}
+	y := math.Sin(deltaLng) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(deltaLng)
+	deg := math.Atan2(y, x) * (180 / math.Pi)
 
-	if lat2 > lat1 {
-		return math.Mod(360+deg, 360)
-	} else {
-		return 180 + deg
-	}
+	return math.Mod(deg+360, 360)
 }
 
 func distance(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
@@ -113,14 +245,57 @@ func distance(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
 	return d
 }
 
-func relativeDirection(angle float64) string {
-	if angle >= 360 {
-		angle = 0
+// elevationAngle returns the angle in degrees above the receiver's horizon
+// of an aircraft groundDistance metres away along the earth's surface, with
+// both altitudes in metres above mean sea level. It allows for the curvature
+// of the earth, so distant aircraft can be below the horizon.
+func elevationAngle(groundDistance float64, receiverAlt float64, aircraftAlt float64) float64 {
+	theta := groundDistance / radius
+	receiver := radius + receiverAlt
+	aircraft := radius + aircraftAlt
+
+	return math.Atan2(aircraft*math.Cos(theta)-receiver, aircraft*math.Sin(theta)) * (180 / math.Pi)
+}
+
+// slantRange returns the straight line distance in metres between the
+// receiver and an aircraft groundDistance metres away, altitudes as for
+// elevationAngle.
+func slantRange(groundDistance float64, receiverAlt float64, aircraftAlt float64) float64 {
+	theta := groundDistance / radius
+	receiver := radius + receiverAlt
+	aircraft := radius + aircraftAlt
+
+	return math.Sqrt(receiver*receiver + aircraft*aircraft - 2*receiver*aircraft*math.Cos(theta))
+}
+
+// radioHorizon returns the furthest ground distance in metres at which an
+// aircraft can be received before the earth gets in the way, using the
+// usual 4/3 earth radius to allow for refraction. Both heights are above the
+// terrain around the receiver, not above sea level.
+func radioHorizon(receiverAlt float64, aircraftAlt float64) float64 {
+	const effectiveRadius = radius * 4 / 3
+	return math.Sqrt(2*effectiveRadius*math.Max(receiverAlt, 0)) + math.Sqrt(2*effectiveRadius*math.Max(aircraftAlt, 0))
+}
+
+// geometricAltitude returns an aircraft's altitude in metres, preferring
+// the geometric altitude and falling back to the barometric one.
+func geometricAltitude(a Aircraft) (float64, bool) {
+	alt := a.AltoGeom
+	if alt == (Altitude{}) {
+		alt = a.AltoBaro
+	}
+	if alt == (Altitude{}) {
+		return 0, false
 	}
-	index := int(math.Abs(angle) / 22.5)
+	return float64(alt.Feet) * 0.3048, true
+}
 
-	direction := directionLut[index]
-	return direction
+// setIfPresent sets an aircraft gauge for the fields dump1090 only reports
+// once they are known.
+func setIfPresent(vec *prometheus.GaugeVec, labels prometheus.Labels, value *float64) {
+	if value != nil {
+		vec.With(labels).Set(*value)
+	}
 }
 
 func aircraftMetrics(aircraftList AircraftList) {
@@ -129,72 +304,175 @@ func aircraftMetrics(aircraftList AircraftList) {
 
 	dump1090AltBaro.Reset()
 	dump1090Distance.Reset()
+	dump1090SlantRange.Reset()
+	dump1090ElevationAngle.Reset()
+	dump1090RadioHorizon.Reset()
+	dump1090HorizonRatioDirection.Reset()
 	dump1090AltGeom.Reset()
 	dump1090BaroRate.Reset()
 	dump1090GroundSpeed.Reset()
 	dump1090NavHeading.Reset()
 	dump1090Rssi.Reset()
+	dump1090Track.Reset()
+	dump1090Ias.Reset()
+	dump1090Tas.Reset()
+	dump1090Mach.Reset()
+	dump1090NavAltitudeMcp.Reset()
+	dump1090NavQnh.Reset()
+	dump1090OnGround.Reset()
+	dump1090Version.Reset()
+	dump1090Nic.Reset()
+	dump1090NacP.Reset()
+	dump1090Sil.Reset()
+	dump1090Gva.Reset()
+	dump1090Sda.Reset()
+	dump1090Alert.Reset()
+	dump1090Spi.Reset()
+	dump1090AircraftStatus.Reset()
 	dump1090MaxRangeDirection.Reset()
 	dump1090MaxRange.Reset()
+	dump1090MaxRangeAltitude.Reset()
 
-	var threshold float64 = 15
+	now := time.Now()
 	var aircraft_observed int = 0
 	var aircraft_with_mlat int = 0
 	var aircraft_with_pos float64 = 0
-	var aircraft_max_range float64 = 0
+	var aircraft_max_range rangeRecord
 
-	aircraft_direction := make(map[string]int)
-	aircraft_direction_max_range := make(map[string]float64)
-	for d := range directionLut {
-		aircraft_direction[directionLut[d]] = 0
-		aircraft_direction_max_range[directionLut[d]] = 0
+	aircraft_direction := make(map[sector]int)
+	aircraft_direction_max_range := make(map[sector]rangeRecord)
+	aircraft_direction_horizon := make(map[sector]float64)
+	for _, d := range sectors() {
+		aircraft_direction[d] = 0
+	}
+	aircraft_band := make(map[bandSector]int)
+	aircraft_band_max_range := make(map[bandSector]float64)
+	for _, b := range bandSectors() {
+		aircraft_band[b] = 0
 	}
 
 	for _, s := range aircraft {
 
 		labels := prometheus.Labels{"flight": strings.TrimSpace(s.Flight), "hex": s.Hex}
-		if s.Seen < threshold {
+		if s.Seen < positionMaxAge {
 			aircraft_observed++
-			if s.SeenPos < threshold {
+			if s.SeenPos < positionMaxAge {
 				aircraft_with_pos++
 				if contains(s.Mlat, "lat") {
 					aircraft_with_mlat++
 				}
-				if s.Latitude != 0 {
+				if s.Latitude != 0 && receiverLocationKnown() {
 					dist := distance(ReceiverLat, ReceiverLon, s.Latitude, s.Longitude)
 					angle := relativeAngle(ReceiverLat, ReceiverLon, s.Latitude, s.Longitude)
-					direction := relativeDirection(angle)
+					direction := bearingSector(angle)
 					log.Debug().
 						Str("Flight", s.Flight).
 						Float64("Ground Speed", s.GroundSpeed).
 						Float64("Distance", dist).
 						Float64("Angle", angle).
-						Str("Direction", direction).
+						Str("Direction", direction.direction).
 						Send()
 					aircraft_direction[direction]++
-					dump1090CountByDirection.With(prometheus.Labels{"direction": direction, "time_period": "latest"}).Set(float64(aircraft_direction[direction]))
-					if dist > float64(aircraft_direction_max_range[direction]) {
-						aircraft_direction_max_range[direction] = dist
-						dump1090MaxRangeDirection.With(prometheus.Labels{"direction": direction, "time_period": "latest"}).Set(dist)
+					record := rangeRecord{Time: now, Distance: dist, Angle: angle, Hex: s.Hex, Flight: labels["flight"]}
+					if dist > aircraft_direction_max_range[direction].Distance {
+						aircraft_direction_max_range[direction] = record
 					}
-					if dist > aircraft_max_range {
-						// Set Max Range Metric
-						aircraft_max_range = dist
-						dump1090MaxRange.With(prometheus.Labels{"time_period": "latest"}).Set(dist)
+					band := bandSector{band: altitudeBand(s), sector: direction}
+					aircraft_band[band]++
+					if dist > aircraft_band_max_range[band] {
+						aircraft_band_max_range[band] = dist
+						dump1090MaxRangeAltitude.With(band.labels("latest")).Set(dist)
+					}
+					if dist > aircraft_max_range.Distance {
+						aircraft_max_range = record
 					}
 					dump1090Distance.With(labels).Set(dist)
+
+					if alt, ok := geometricAltitude(s); ok {
+						// Terrain is taken to be level with the ground under
+						// the antenna.
+						horizon := radioHorizon(AntennaHeight, alt-(ReceiverAlt-AntennaHeight))
+						dump1090SlantRange.With(labels).Set(slantRange(dist, ReceiverAlt, alt))
+						dump1090ElevationAngle.With(labels).Set(elevationAngle(dist, ReceiverAlt, alt))
+						dump1090RadioHorizon.With(labels).Set(horizon)
+						if ratio := dist / horizon; horizon > 0 && ratio > aircraft_direction_horizon[direction] {
+							aircraft_direction_horizon[direction] = ratio
+						}
+					}
 				}
 			}
 
 		}
 
-		dump1090AltBaro.With(labels).Set(float64(s.AltoBaro))
-		dump1090AltGeom.With(labels).Set(float64(s.AltoGeom))
+		dump1090AltBaro.With(labels).Set(float64(s.AltoBaro.Feet))
+		dump1090AltGeom.With(labels).Set(float64(s.AltoGeom.Feet))
 		dump1090BaroRate.With(labels).Set(float64(s.BaroRate))
 		dump1090GroundSpeed.With(labels).Set(float64(s.GroundSpeed))
 		dump1090NavHeading.With(labels).Set(float64(s.NavHeading))
 		dump1090Rssi.With(labels).Set(float64(s.RSSi))
 
+		onGround := 0.0
+		if s.AltoBaro.Ground {
+			onGround = 1
+		}
+		dump1090OnGround.With(labels).Set(onGround)
+
+		setIfPresent(dump1090Track, labels, s.Track)
+		setIfPresent(dump1090Ias, labels, s.IAS)
+		setIfPresent(dump1090Tas, labels, s.TAS)
+		setIfPresent(dump1090Mach, labels, s.Mach)
+		setIfPresent(dump1090NavAltitudeMcp, labels, s.NavAltitudeMcp)
+		setIfPresent(dump1090NavQnh, labels, s.NavQnh)
+		setIfPresent(dump1090Version, labels, s.Version)
+		setIfPresent(dump1090Nic, labels, s.Nic)
+		setIfPresent(dump1090NacP, labels, s.NacP)
+		setIfPresent(dump1090Sil, labels, s.Sil)
+		setIfPresent(dump1090Gva, labels, s.Gva)
+		setIfPresent(dump1090Sda, labels, s.Sda)
+		setIfPresent(dump1090Alert, labels, s.Alert)
+		setIfPresent(dump1090Spi, labels, s.Spi)
+
+		var country, military string
+		if countryLabels {
+			country, _ = icaoCountry(s.Hex)
+			military = strconv.FormatBool(icaoMilitary(s.Hex))
+		}
+		dump1090AircraftStatus.With(prometheus.Labels{
+			"flight":    labels["flight"],
+			"hex":       s.Hex,
+			"squawk":    s.Squawk,
+			"type":      s.Type,
+			"category":  s.Category,
+			"nav_modes": strings.Join(s.NavModes, ","),
+			"tisb":      strings.Join(s.Tisb, ","),
+			"country":   country,
+			"military":  military,
+		}).Set(1)
+
+	}
+
+	// Every sector is set, so a sector the last aircraft left drops to 0.
+	for direction, count := range aircraft_direction {
+		dump1090CountByDirection.With(direction.labels("latest")).Set(float64(count))
+	}
+
+	for direction, record := range aircraft_direction_max_range {
+		labels := direction.labels("latest")
+		for k, v := range record.labels("latest") {
+			labels[k] = v
+		}
+		dump1090MaxRangeDirection.With(labels).Set(record.Distance)
+		rangeRecords.observe(record)
+	}
+	for direction, ratio := range aircraft_direction_horizon {
+		dump1090HorizonRatioDirection.With(direction.labels("latest")).Set(ratio)
+	}
+	if aircraft_max_range.Distance > 0 {
+		dump1090MaxRange.With(aircraft_max_range.labels("latest")).Set(aircraft_max_range.Distance)
+	}
+	rangeRecords.setMetrics(now)
+	for band, count := range aircraft_band {
+		dump1090CountByAltitude.With(band.labels("latest")).Set(float64(count))
 	}
 
 	metrics.RecentAircraftObserved(statLabels{TimePeriod: "latest"}).Set(float64(aircraft_observed))
@@ -202,6 +480,20 @@ func aircraftMetrics(aircraftList AircraftList) {
 	dump1090CountWithMlat.With(prometheus.Labels{"time_period": "latest"}).Set(float64(aircraft_with_mlat))
 	dump1090CountWithPos.With(prometheus.Labels{"time_period": "latest"}).Set(float64(aircraft_with_pos))
 
+	locationKnown := 0.0
+	if receiverLocationKnown() {
+		locationKnown = 1
+	}
+	opsMetrics.ReceiverLocationKnown().Set(locationKnown)
+
+	liveAircraft.set(aircraft)
+	emergencyMetrics(aircraft, now)
+	zoneMetrics(aircraft, now)
+	sessionMetrics(aircraft, now)
+	distinctMetrics(aircraft, now)
+	enrichMetrics(aircraft)
+	countryMetrics(aircraft)
+
 }
 
 func statMetrics(stats Statistics) {
@@ -281,94 +573,106 @@ func statMetrics(stats Statistics) {
 
 }
 
-func readAircraftFile(path string) {
+// loadAircraftFile reads and parses aircraft.json from a local directory or
+// a URL.
+func loadAircraftFile(path string) (AircraftList, error) {
 
+	var aircraftList AircraftList
 	var aircraft_path string = path + "aircraft.json"
-	_, err := url.ParseRequestURI(aircraft_path)
-	if err != nil {
-		if _, err := os.Stat(aircraft_path); errors.Is(err, os.ErrNotExist) {
-			// Do something because it doesn't exist
-		} else {
-			// Open the file
-			jsonFile, err := os.Open(aircraft_path)
 
-			// Increment the prom read metric
-			opsMetrics.AirCraftFileReads().Inc()
+	if isURL(aircraft_path) {
+		opsMetrics.AirCraftFileReads().Inc()
+		err := getJson(aircraft_path, &aircraftList)
+		if aircraftList.Now > 0 {
+			opsMetrics.FileAge(fileLabels{File: "aircraft.json"}).Set(epochAge(aircraftList.Now))
+		}
+		return aircraftList, err
+	}
 
-			// Print the error if that happens.
-			if err != nil {
-				log.Error().Err(err).Msg("Error opening aircraft.json")
-			}
+	info, err := os.Stat(aircraft_path)
+	if err != nil {
+		return aircraftList, err
+	}
 
-			// defer file close
-			defer jsonFile.Close()
+	// Increment the prom read metric
+	opsMetrics.AirCraftFileReads().Inc()
+	opsMetrics.FileAge(fileLabels{File: "aircraft.json"}).Set(time.Since(info.ModTime()).Seconds())
 
-			// read file
-			byteValue, _ := ioutil.ReadAll(jsonFile)
+	byteValue, err := ioutil.ReadFile(aircraft_path)
+	if err != nil {
+		return aircraftList, err
+	}
 
-			// Initialize list of aircraft
-			var aircraftList AircraftList
+	err = json.Unmarshal(byteValue, &aircraftList)
+	return aircraftList, err
+}
+
+// loadStatsFile reads and parses stats.json from a local directory or a URL.
+func loadStatsFile(path string) (Statistics, error) {
 
-			// Unmarshal to aircraft list
-			json.Unmarshal(byteValue, &aircraftList)
+	var stats Statistics
+	var stats_path string = path + "stats.json"
 
-			aircraftMetrics(aircraftList)
+	if isURL(stats_path) {
+		opsMetrics.StatsFileReads().Inc()
+		err := getJson(stats_path, &stats)
+		if stats.Latest.End > 0 {
+			opsMetrics.FileAge(fileLabels{File: "stats.json"}).Set(epochAge(stats.Latest.End))
 		}
-	} else {
-		opsMetrics.AirCraftFileReads().Inc()
-		aircraftList := new(AircraftList)
-		getJson(aircraft_path, aircraftList)
-		aircraftMetrics(*aircraftList)
+		return stats, err
 	}
-}
-
-func readStatsFile(path string) {
 
-	var stats_path string = path + "stats.json"
-	_, err := url.ParseRequestURI(stats_path)
+	info, err := os.Stat(stats_path)
 	if err != nil {
-		if _, err := os.Stat(stats_path); errors.Is(err, os.ErrNotExist) {
-			// Do something because it doesn't exist
-		} else {
-			// Open the file
-			jsonFile, err := os.Open(stats_path)
+		return stats, err
+	}
 
-			// Increment the prom read metric
-			opsMetrics.StatsFileReads().Inc()
+	// Increment the prom read metric
+	opsMetrics.StatsFileReads().Inc()
+	opsMetrics.FileAge(fileLabels{File: "stats.json"}).Set(time.Since(info.ModTime()).Seconds())
 
-			// Print the error if that happens.
-			if err != nil {
-				log.Error().Err(err).Msg("Error opening stats.json")
-			}
+	byteValue, err := ioutil.ReadFile(stats_path)
+	if err != nil {
+		return stats, err
+	}
 
-			// defer file close
-			defer jsonFile.Close()
+	err = json.Unmarshal(byteValue, &stats)
+	return stats, err
+}
 
-			// read file
-			byteValue, _ := ioutil.ReadAll(jsonFile)
+func readAircraftFile(path string) {
+
+	aircraftList, err := loadAircraftFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		// An empty read would look like every aircraft leaving at once
+		// to the zones, visits and distinct counts.
+		log.Error().Err(err).Msg("Error reading aircraft.json")
+		return
+	}
 
-			// Initialize list of aircraft
-			var stats Statistics
+	aircraftMetrics(aircraftList)
+}
 
-			// Unmarshal to aircraft list
-			json.Unmarshal(byteValue, &stats)
+func readStatsFile(path string) {
 
-			statMetrics(stats)
-		}
-	} else {
-		opsMetrics.StatsFileReads().Inc()
-		stats := new(Statistics)
-		getJson(stats_path, stats)
-		statMetrics(*stats)
+	stats, err := loadStatsFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading stats.json")
 	}
 
+	statMetrics(stats)
 }
 
 func readReceiverInfo(path string) {
 
 	var receiver_path string = path + "receiver.json"
-	_, err := url.ParseRequestURI(receiver_path)
-	if err != nil {
+	if !isURL(receiver_path) {
 		if _, err := os.Stat(receiver_path); errors.Is(err, os.ErrNotExist) {
 			// Do something because it doesn't exist
 		} else {
@@ -404,20 +708,55 @@ func readReceiverInfo(path string) {
 
 }
 
-func readFilesTicker(path string) {
+// setReceiverLocation overrides the location read from receiver.json with
+// any of lat, lon and alt that are set.
+func setReceiverLocation(lat string, lon string, alt string) error {
+	for _, v := range []struct {
+		name  string
+		value string
+		dest  *float64
+		limit float64
+	}{
+		{"lat", lat, &ReceiverLat, 90},
+		{"lon", lon, &ReceiverLon, 180},
+		{"alt", alt, &ReceiverAlt, math.Inf(1)},
+	} {
+		if v.value == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v.value, 64)
+		if err != nil || math.Abs(f) > v.limit {
+			return fmt.Errorf("invalid receiver %s %q", v.name, v.value)
+		}
+		*v.dest = f
+	}
+	return nil
+}
+
+// receiverLocationKnown reports whether distances and directions can be
+// worked out. dump1090 reports 0,0 when it was not given a location.
+func receiverLocationKnown() bool {
+	return ReceiverLat != 0 || ReceiverLon != 0
+}
+
+func readFilesTicker(path string, readAircraft bool) {
 
 	aircraftTicker := time.NewTicker(5 * time.Second)
 	statsTicker := time.NewTicker(30 * time.Second)
 
-	readAircraftFile(path)
+	if readAircraft {
+		readAircraftFile(path)
+	}
 	readStatsFile(path)
 
-	go func() {
-		for {
-			<-aircraftTicker.C
-			readAircraftFile(path)
-		}
-	}()
+	if readAircraft {
+		go func() {
+			for {
+				<-aircraftTicker.C
+				readAircraftFile(path)
+			}
+		}()
+	}
 
 	go func() {
 		for {
@@ -428,28 +767,65 @@ func readFilesTicker(path string) {
 
 }
 
-func init() {
-	// reg := prometheus.NewRegistry()
-	gotoprom.MustInit(&metrics, "dump1090")
+// registerMetrics creates the gotoprom metrics and registers every metric
+// the exporter writes with registerer.
+func registerMetrics(registerer prometheus.Registerer) {
+	initializer := gotoprom.NewInitializer(registerer)
+	initializer.MustAddBuilder(prometheusvanilla.CounterType, prometheusvanilla.BuildCounter)
+	initializer.MustAddBuilder(prometheusvanilla.GaugeType, prometheusvanilla.BuildGauge)
+	initializer.MustAddBuilder(prometheusvanilla.HistogramType, prometheusvanilla.BuildHistogram)
+	initializer.MustAddBuilder(prometheusvanilla.SummaryType, prometheusvanilla.BuildSummary)
 
-	gotoprom.MustInit(&opsMetrics, "dump1090")
+	initializer.MustInit(&metrics, "dump1090")
+	initializer.MustInit(&opsMetrics, "dump1090")
 
 	// Metrics have to be registered to be exposed:
-	prometheus.MustRegister(dump1090AltBaro)
-	prometheus.MustRegister(dump1090AltGeom)
-	prometheus.MustRegister(dump1090BaroRate)
-	prometheus.MustRegister(dump1090GroundSpeed)
-	prometheus.MustRegister(dump1090NavHeading)
-	prometheus.MustRegister(dump1090Rssi)
-	prometheus.MustRegister(dump1090Messages)
-	prometheus.MustRegister(dump1090Distance)
-	prometheus.MustRegister(dump1090MaxRangeDirection)
-	prometheus.MustRegister(dump1090MaxRange)
-	prometheus.MustRegister(dump1090CountByDirection)
-	prometheus.MustRegister(dump1090CountWithPos)
-	prometheus.MustRegister(dump1090CountWithMlat)
-	// prometheus.MustRegister(dump1090Observed)
+	registerer.MustRegister(dump1090AltBaro)
+	registerer.MustRegister(dump1090AltGeom)
+	registerer.MustRegister(dump1090BaroRate)
+	registerer.MustRegister(dump1090GroundSpeed)
+	registerer.MustRegister(dump1090NavHeading)
+	registerer.MustRegister(dump1090Rssi)
+	registerer.MustRegister(dump1090Track)
+	registerer.MustRegister(dump1090Ias)
+	registerer.MustRegister(dump1090Tas)
+	registerer.MustRegister(dump1090Mach)
+	registerer.MustRegister(dump1090NavAltitudeMcp)
+	registerer.MustRegister(dump1090NavQnh)
+	registerer.MustRegister(dump1090OnGround)
+	registerer.MustRegister(dump1090Version)
+	registerer.MustRegister(dump1090Nic)
+	registerer.MustRegister(dump1090NacP)
+	registerer.MustRegister(dump1090Sil)
+	registerer.MustRegister(dump1090Gva)
+	registerer.MustRegister(dump1090Sda)
+	registerer.MustRegister(dump1090Alert)
+	registerer.MustRegister(dump1090Spi)
+	registerer.MustRegister(dump1090AircraftStatus)
+	registerer.MustRegister(dump1090EmergencyAircraft)
+	registerer.MustRegister(dump1090AircraftByCountry)
+	registerer.MustRegister(dump1090ZoneAircraft)
+	registerer.MustRegister(dump1090DistinctAircraft)
+	registerer.MustRegister(dump1090AircraftInfo)
+	registerer.MustRegister(dump1090AircraftDbAge)
+	registerer.MustRegister(dump1090Messages)
+	registerer.MustRegister(dump1090Distance)
+	registerer.MustRegister(dump1090SlantRange)
+	registerer.MustRegister(dump1090ElevationAngle)
+	registerer.MustRegister(dump1090RadioHorizon)
+	registerer.MustRegister(dump1090HorizonRatioDirection)
+	registerer.MustRegister(dump1090MaxRangeDirection)
+	registerer.MustRegister(dump1090MaxRange)
+	registerer.MustRegister(dump1090CountByDirection)
+	registerer.MustRegister(dump1090MaxRangeAltitude)
+	registerer.MustRegister(dump1090CountByAltitude)
+	registerer.MustRegister(dump1090CountWithPos)
+	registerer.MustRegister(dump1090CountWithMlat)
+	// registerer.MustRegister(dump1090Observed)
+}
 
+func init() {
+	registerMetrics(prometheus.DefaultRegisterer)
 }
 
 func main() {
@@ -457,6 +833,41 @@ func main() {
 	path := flag.String("path", "/run/dump1090-fa/", "Path to json files. Default /run/dump1090-fa/")
 	port := flag.String("port", "3000", "Port to expose metrics")
 	debug := flag.Bool("debug", false, "sets log level to debug")
+	watch := flag.Bool("watch", false, "re-read json files when dump1090 rewrites them instead of on a fixed interval (local paths on linux only)")
+	emergencyWebhook := flag.String("emergency-webhook", "", "comma separated URLs to POST emergency start and end events to")
+	emergencyClearAfter := flag.Duration("emergency-clear-after", 5*time.Minute, "how long an aircraft must stop reporting an emergency before it is considered over")
+	sessionTimeout := flag.Duration("session-timeout", sessions.timeout, "how long an aircraft must be silent for its visit to end")
+	sessionFilePath := flag.String("session-file", "", "file to persist the visits in progress to across restarts")
+	flightLogPath := flag.String("flight-log", "", "file to log every completed aircraft visit to, served at /api/flights")
+	flightLogRetention := flag.Duration("flight-log-retention", 30*24*time.Hour, "how long completed visits are kept in the flight log, 0 keeps them forever")
+	flightLogMax := flag.Int("flight-log-max", 100000, "most completed visits kept in the flight log, 0 for no limit")
+	zoneFilePath := flag.String("zones", "", "json file of named zones to count aircraft in")
+	zoneWebhook := flag.String("zone-webhook", "", "comma separated URLs to POST zone entry and exit events to")
+	enrich := flag.Bool("enrich", false, "load the OpenSky aircraft database and expose registration, model and operator as dump1090_aircraft_info")
+	api := flag.Bool("api", false, "serve the aircraft database and flight log HTTP API under /api, loading the aircraft database")
+	dbRegistrations := flag.String("db-registration-prefix", "", "comma separated registration prefixes to load from the aircraft database, e.g. C-,N")
+	dbRanges := flag.String("db-icao-range", "", "comma separated hex ranges to load from the aircraft database, e.g. c00000-c3ffff")
+	dbCountries := flag.String("db-country", "", "comma separated countries of registration to load from the aircraft database, e.g. Canada")
+	dbFile := flag.String("db-file", "", "pre-staged aircraft database to load instead of downloading one: an OpenSky CSV (optionally gzipped), a tar1090 db directory or a BaseStation.sqb")
+	dbFormat := flag.String("db-format", "", "format of -db-file: opensky, mictronics or basestation. Detected from the file when empty")
+	dbUrl := flag.String("db-url", aircraftDbUrl, "URL to download the aircraft database from when -db-file is not set")
+	dbSha256 := flag.String("db-sha256", "", "expected SHA-256 of the aircraft database file, pins a downloaded database to that exact file so it is no longer refreshed weekly")
+	dbReload := flag.Duration("db-reload", aircraftDbReload, "how often to reload the aircraft database, 0 to only reload on SIGHUP")
+	countryLabelsFlag := flag.Bool("country-labels", false, "add the country of registration and military flag from the ICAO address allocation to dump1090_aircraft_status")
+	scrape := flag.Bool("scrape", false, "read json files when /metrics is scraped instead of in the background")
+	sectorCount := flag.Int("sectors", bearingSectors, "number of bearing sectors for the direction metrics: 8, 16, 36 or 72")
+	altitudeBandsFlag := flag.String("altitude-bands", "10000,25000", "comma separated altitude band boundaries in feet for the range by altitude metrics")
+	rangeFilePath := flag.String("range-file", "", "file to persist the rolling max range records to across restarts")
+	lat := flag.String("lat", os.Getenv("DUMP1090_LAT"), "receiver latitude, overrides receiver.json (env DUMP1090_LAT)")
+	lon := flag.String("lon", os.Getenv("DUMP1090_LON"), "receiver longitude, overrides receiver.json (env DUMP1090_LON)")
+	alt := flag.String("alt", os.Getenv("DUMP1090_ALT"), "receiver antenna altitude above mean sea level in metres (env DUMP1090_ALT)")
+	antennaHeight := flag.Float64("antenna-height", AntennaHeight, "receiver antenna height above the surrounding ground in metres, for the radio horizon")
+	requireLocation := flag.Bool("require-location", false, "exit at startup if the receiver location is not known")
+	timezone := flag.String("timezone", "Local", "timezone whose midnight starts a new day for the distinct aircraft count, e.g. America/Edmonton")
+	distinctFilePath := flag.String("distinct-file", "", "file to persist the distinct aircraft sets to across restarts")
+	sbs := flag.String("sbs", "", "host:port of a SBS/BaseStation feed (port 30003) to read aircraft from instead of aircraft.json")
+	beast := flag.String("beast", "", "host:port of a Beast binary feed (port 30005) to read aircraft from instead of aircraft.json")
+	avr := flag.String("avr", "", "host:port of a raw AVR feed (port 30002) to read aircraft from instead of aircraft.json")
 	flag.Parse()
 
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
@@ -469,12 +880,155 @@ func main() {
 	log.Info().Msg("Listen Port:" + *port)
 
 	readReceiverInfo(*path)
+	if err := setReceiverLocation(*lat, *lon, *alt); err != nil {
+		log.Fatal().Err(err).Msg("Invalid receiver location")
+	}
+	AntennaHeight = *antennaHeight
+	if !receiverLocationKnown() {
+		if *requireLocation {
+			log.Fatal().Msg("Receiver location unknown, set -lat and -lon or the location in dump1090")
+		}
+		log.Warn().Msg("Receiver location unknown, range and direction metrics are disabled")
+	} else {
+		log.Info().Float64("lat", ReceiverLat).Float64("lon", ReceiverLon).Float64("alt", ReceiverAlt).Msg("Receiver location")
+	}
+
+	if *emergencyWebhook != "" {
+		emergencies.webhooks = strings.Split(*emergencyWebhook, ",")
+	}
+	emergencies.clearAfter = *emergencyClearAfter
+
+	// stops run on shutdown.
+	var stops []func()
+	sessions.timeout = *sessionTimeout
+	if *sessionFilePath != "" {
+		if err := sessions.load(*sessionFilePath); err != nil {
+			log.Error().Err(err).Str("path", *sessionFilePath).Msg("Error loading aircraft visits")
+		}
+		go saveEvery(*sessionFilePath, time.Minute, sessions.save)
+		stops = append(stops, func() { saveState(*sessionFilePath, sessions.save) })
+	}
+	if *flightLogPath != "" {
+		opened, err := openFlightLog(*flightLogPath, *flightLogRetention, *flightLogMax, time.Now())
+		if err != nil {
+			log.Fatal().Err(err).Str("path", *flightLogPath).Msg("Error opening flight log")
+		}
+		flights = opened
+	}
+
+	if *zoneFilePath != "" {
+		list, err := loadZones(*zoneFilePath)
+		if err != nil {
+			log.Fatal().Err(err).Str("path", *zoneFilePath).Msg("Error loading zones")
+		}
+		zones.setZones(list)
+		log.Info().Int("zones", len(list)).Msg("Loaded zones")
+	}
+	if *zoneWebhook != "" {
+		zones.webhooks = strings.Split(*zoneWebhook, ",")
+	}
+	countryLabels = *countryLabelsFlag
+	if err := setBearingSectors(*sectorCount); err != nil {
+		log.Fatal().Err(err).Msg("Invalid sectors")
+	}
+	if err := setAltitudeBands(*altitudeBandsFlag); err != nil {
+		log.Fatal().Err(err).Msg("Invalid altitude bands")
+	}
+	if *rangeFilePath != "" {
+		if err := rangeRecords.load(*rangeFilePath); err != nil {
+			log.Error().Err(err).Str("path", *rangeFilePath).Msg("Error loading max range records")
+		}
+		go saveEvery(*rangeFilePath, time.Minute, rangeRecords.save)
+		stops = append(stops, func() { saveState(*rangeFilePath, rangeRecords.save) })
+	}
+
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid timezone")
+	}
+	distinctAircraft = newDistinctTracker(loc)
+	if *distinctFilePath != "" {
+		if err := distinctAircraft.load(*distinctFilePath, time.Now()); err != nil {
+			log.Error().Err(err).Str("path", *distinctFilePath).Msg("Error loading distinct aircraft")
+		}
+		go saveEvery(*distinctFilePath, time.Minute, distinctAircraft.save)
+		stops = append(stops, func() { saveState(*distinctFilePath, distinctAircraft.save) })
+	}
+
+	var table *aircraftTable
+	if *sbs != "" || *beast != "" || *avr != "" {
+		table = newAircraftTable()
+	}
+
+	// The scrape collector rebuilds the metrics, so it has to exist before
+	// any feed starts writing to them.
+	metricsHandler := promhttp.Handler()
+	if *scrape {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collectors.NewGoCollector())
+		registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		registry.MustRegister(newScrapeCollector(*path, table))
+		metricsHandler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	}
+
+	if *sbs != "" {
+		log.Info().Msg("SBS feed:" + *sbs)
+		go streamSBS(*sbs, table)
+	}
+	if *beast != "" {
+		log.Info().Msg("Beast feed:" + *beast)
+		go streamBeast(*beast, table)
+	}
+	if *avr != "" {
+		log.Info().Msg("AVR feed:" + *avr)
+		go streamAVR(*avr, table)
+	}
+
+	if *scrape {
+		log.Info().Msg("Reading json files on scrape")
+	} else {
+		if table != nil {
+			go tableTicker(table)
+		}
+		if *watch {
+			go watchFiles(*path, table == nil)
+		} else {
+			go readFilesTicker(*path, table == nil)
+		}
+	}
+
+	filter, err := newDbFilter(*dbRegistrations, *dbRanges, *dbCountries)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid aircraft database filter")
+	}
+	aircraftFilter = filter
+	aircraftDbFile = *dbFile
+	if *dbFormat != "" {
+		loader, ok := aircraftLoaders[*dbFormat]
+		if !ok {
+			log.Fatal().Str("format", *dbFormat).Msg("Unknown aircraft database format")
+		}
+		aircraftDbLoader = loader
+	}
+	aircraftDbUrl = *dbUrl
+	aircraftDbSha256 = *dbSha256
+	aircraftDbReload = *dbReload
 
-	go readFilesTicker(*path)
+	enrichAircraft = *enrich
+	if *enrich || *api {
+		go flightInit()
+	} else {
+		// Nothing uses the database, but a SIGHUP meant to reload it
+		// should not kill the exporter.
+		signal.Ignore(syscall.SIGHUP)
+	}
 
-	// go flightInit()
+	go shutdownOnSignal(stops)
 
-	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/metrics", metricsHandler)
+	if *api {
+		registerApi(http.DefaultServeMux)
+	}
 	if err := http.ListenAndServe(":"+*port, nil); err != nil {
 		log.Fatal().Err(err).Msg("Startup failed")
 	}
@@ -1,10 +1,35 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
+// useFreshTrackers gives a test that runs aircraftMetrics empty trackers,
+// and puts the previous ones back when it ends, so the aircraft it reads do
+// not show up in the zones, visits, distinct counts or range records of
+// later tests.
+func useFreshTrackers(t *testing.T) {
+	t.Helper()
+	prevRange, prevZones, prevSessions := rangeRecords, zones, sessions
+	prevDistinct, prevEmergencies, prevLive := distinctAircraft, emergencies, liveAircraft
+	rangeRecords = newRangeHistory()
+	zones = newZoneTracker()
+	sessions = newSessionTracker()
+	distinctAircraft = newDistinctTracker(time.Local)
+	emergencies = newEmergencyTracker()
+	liveAircraft = &liveState{}
+	t.Cleanup(func() {
+		rangeRecords, zones, sessions = prevRange, prevZones, prevSessions
+		distinctAircraft, emergencies, liveAircraft = prevDistinct, prevEmergencies, prevLive
+	})
+}
+
 func TestDistance(t *testing.T) {
 	var lat1 float64 = 50.72
 	var lon1 float64 = -113.99
@@ -18,21 +43,37 @@ func TestDistance(t *testing.T) {
 }
 
 func TestRelativeAngle(t *testing.T) {
-	var lat1 float64 = 50.72
-	var lon1 float64 = -113.99
-	var lat2 float64 = 51.72
-	var lon2 float64 = -115.99
 
-	rel_angle := relativeAngle(lat1, lon1, lat2, lon2)
-	if rel_angle != 296.565051177078 {
-		t.Errorf("Angle was incorrect. Expected: 296.565051177078")
+	var tests = []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		want                   float64
+	}{
+		{"calgary north west", 50.72, -113.99, 51.72, -115.99, 309.376758},
+		// Worked example from the great-circle navigation article on Wikipedia.
+		{"valparaiso to shanghai", -33, -71.6, 31.4, 121.8, 265.586978},
+		{"east along a parallel", 51, 0, 51, 1, 89.611423},
+		{"due south", 51, -114, 50, -114, 180},
+		{"due north", 0, 0, 10, 0, 0},
+		{"east across the antimeridian", 0, 179, 0, -179, 90},
+		{"north east across the antimeridian", 51, 179.5, 51.5, -179.5, 50.992299},
+		{"west across the antimeridian", 0, -179, 0, 179, 270},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := relativeAngle(tt.lat1, tt.lon1, tt.lat2, tt.lon2)
+			if math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("got %f, want %f", got, tt.want)
+			}
+		})
 	}
 }
 
 func TestRelativeDirection(t *testing.T) {
 	var acceptedDirection = []string{"N", "NE", "E", "SE", "S", "SW", "W", "NW"}
 	for i := 0; i <= 360; i++ {
-		direction := relativeDirection(float64(i))
+		direction := bearingSector(float64(i)).direction
 		if contains(acceptedDirection, direction) == false {
 			t.Errorf("Direction was incorrect.")
 		}
@@ -40,6 +81,51 @@ func TestRelativeDirection(t *testing.T) {
 
 }
 
+func TestBearingSector(t *testing.T) {
+	defer setBearingSectors(8)
+
+	var tests = []struct {
+		sectors   int
+		angle     float64
+		bearing   float64
+		direction string
+	}{
+		{8, 0, 0, "N"},
+		{8, 22.4, 0, "N"},
+		{8, 22.5, 45, "NE"},
+		{8, 337.5, 0, "N"},
+		{8, 309.4, 315, "NW"},
+		{16, 11.25, 22.5, "NNE"},
+		{16, 359, 0, "N"},
+		{16, 200, 202.5, "SSW"},
+		{36, 4.9, 0, "N"},
+		{36, 5, 10, "N"},
+		{36, 95, 100, "E"},
+		{72, 357.5, 0, "N"},
+		{72, 47, 45, "NE"},
+		{72, 33, 35, "NE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%g", tt.sectors, tt.angle), func(t *testing.T) {
+			if err := setBearingSectors(tt.sectors); err != nil {
+				t.Fatal(err)
+			}
+			got := bearingSector(tt.angle)
+			if got.bearing != tt.bearing || got.direction != tt.direction {
+				t.Errorf("got %g %s, want %g %s", got.bearing, got.direction, tt.bearing, tt.direction)
+			}
+			if n := len(sectors()); n != tt.sectors {
+				t.Errorf("got %d sectors, want %d", n, tt.sectors)
+			}
+		})
+	}
+
+	if err := setBearingSectors(12); err == nil {
+		t.Error("expected an error for 12 sectors")
+	}
+}
+
 func TestDegrees2Radians(t *testing.T) {
 
 	var tests = []struct {
@@ -83,3 +169,178 @@ func TestContains(t *testing.T) {
 	}
 
 }
+
+func TestIsURL(t *testing.T) {
+	var tests = []struct {
+		path string
+		want bool
+	}{
+		{"/run/dump1090-fa/aircraft.json", false},
+		{"./data/aircraft.json", false},
+		{"http://piaware.local/dump1090-fa/data/aircraft.json", true},
+		{"https://piaware.local/skyaware/data/", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			ans := isURL(tt.path)
+			if ans != tt.want {
+				t.Errorf("got %t, want %t", ans, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestAircraftListAltitude(t *testing.T) {
+	data := []byte(`{"now":1,"messages":2,"aircraft":[
+		{"hex":"c0ffee","alt_baro":"ground","alt_geom":-75,"track":0,"squawk":"1200"},
+		{"hex":"c0fffe","alt_baro":45000,"alt_geom":45325,"nic":8,"nav_modes":["autopilot","vnav"]}
+	]}`)
+
+	var aircraftList AircraftList
+	if err := json.Unmarshal(data, &aircraftList); err != nil {
+		t.Fatal(err)
+	}
+
+	ground := aircraftList.Aircraft[0]
+	if !ground.AltoBaro.Ground || ground.AltoGeom.Feet != -75 || ground.Track == nil || *ground.Track != 0 || ground.Squawk != "1200" {
+		t.Errorf("unexpected aircraft %+v", ground)
+	}
+	high := aircraftList.Aircraft[1]
+	if high.AltoBaro.Ground || high.AltoBaro.Feet != 45000 || high.Track != nil || high.Nic == nil || len(high.NavModes) != 2 {
+		t.Errorf("unexpected aircraft %+v", high)
+	}
+
+	out, err := json.Marshal(ground.AltoBaro)
+	if err != nil || string(out) != `"ground"` {
+		t.Errorf("got %s, want \"ground\"", out)
+	}
+}
+
+func TestAircraftMetricsDirection(t *testing.T) {
+	// aircraftMetrics does not reset the direction counts, so start and
+	// leave them empty for the other tests.
+	resetDirections := func() {
+		dump1090CountByDirection.Reset()
+		dump1090MaxRangeDirection.Reset()
+		dump1090MaxRange.Reset()
+	}
+	resetDirections()
+	defer resetDirections()
+	useFreshTrackers(t)
+
+	lat, lon := ReceiverLat, ReceiverLon
+	ReceiverLat, ReceiverLon = 50.72, -113.99
+	defer func() { ReceiverLat, ReceiverLon = lat, lon }()
+
+	// The great-circle bearing is 303.5°, in the north west sector. The
+	// flat-earth angle of the raw degree differences is 291.8°, in the west
+	// sector.
+	aircraftMetrics(AircraftList{Aircraft: []Aircraft{{Hex: "c05f0a", Latitude: 51.72, Longitude: -116.49}}})
+
+	if got := testutil.ToFloat64(dump1090CountByDirection.WithLabelValues("NW", "315", "latest")); got != 1 {
+		t.Errorf("got %f aircraft to the north west, want 1", got)
+	}
+	if got := testutil.ToFloat64(dump1090CountByDirection.WithLabelValues("W", "270", "latest")); got != 0 {
+		t.Errorf("got %f aircraft to the west, want 0", got)
+	}
+}
+
+func TestElevationAngle(t *testing.T) {
+
+	var tests = []struct {
+		name                     string
+		dist, receiver, aircraft float64
+		want                     float64
+	}{
+		{"overhead", 0, 0, 10000, 90},
+		{"45 degrees nearby", 1000, 0, 1000, 44.993255},
+		{"level with the receiver", 10000, 1000, 1000, -0.044966},
+		// 250 km away at 35,000 ft is just above the horizon of a
+		// receiver on a hill.
+		{"distant", 250000, 500, 10668, 1.202563},
+		{"below the horizon", 450000, 0, 10668, -0.667136},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := elevationAngle(tt.dist, tt.receiver, tt.aircraft)
+			if math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("got %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetReceiverLocation(t *testing.T) {
+	defer func() { ReceiverLat, ReceiverLon, ReceiverAlt = 0, 0, 0 }()
+
+	ReceiverLat, ReceiverLon = 1, 2
+	if err := setReceiverLocation("51.05", "", "1045"); err != nil {
+		t.Fatal(err)
+	}
+	if ReceiverLat != 51.05 || ReceiverLon != 2 || ReceiverAlt != 1045 {
+		t.Errorf("got %f,%f,%f", ReceiverLat, ReceiverLon, ReceiverAlt)
+	}
+	if !receiverLocationKnown() {
+		t.Error("location should be known")
+	}
+
+	for _, bad := range [][3]string{{"91", "", ""}, {"", "-181", ""}, {"", "", "high"}} {
+		if err := setReceiverLocation(bad[0], bad[1], bad[2]); err == nil {
+			t.Errorf("expected an error for %v", bad)
+		}
+	}
+}
+
+func TestSlantRange(t *testing.T) {
+	if got := slantRange(0, 100, 10100); math.Abs(got-10000) > 1e-6 {
+		t.Errorf("got %f overhead, want 10000", got)
+	}
+	if got := slantRange(100000, 100, 10668); math.Abs(got-100639.847078) > 1e-3 {
+		t.Errorf("got %f, want 100639.847078", got)
+	}
+}
+
+func TestRadioHorizon(t *testing.T) {
+	// The usual rule of thumb is 4.12 km per square root metre of height.
+	if got := radioHorizon(0, 10668); math.Abs(got-425725.507810) > 1e-3 {
+		t.Errorf("got %f at 35,000 ft, want 425725.507810", got)
+	}
+	if got := radioHorizon(30, 10668); math.Abs(got-448301.601360) > 1e-3 {
+		t.Errorf("got %f from a 30 m mast, want 448301.601360", got)
+	}
+	if got := radioHorizon(-10, 0); got != 0 {
+		t.Errorf("got %f below sea level, want 0", got)
+	}
+}
+
+func TestAircraftMetricsHorizon(t *testing.T) {
+	ReceiverLat, ReceiverLon, ReceiverAlt = 51, -114, 1000
+	defer func() { ReceiverLat, ReceiverLon, ReceiverAlt = 0, 0, 0 }()
+	useFreshTrackers(t)
+
+	aircraftMetrics(AircraftList{Aircraft: []Aircraft{
+		{Hex: "c00001", Latitude: 52, Longitude: -114, AltoGeom: Altitude{Feet: 35000}},
+		{Hex: "c00002", Latitude: 51.1, Longitude: -114, AltoBaro: Altitude{Feet: 5000}},
+		{Hex: "c00003", Latitude: 51, Longitude: -113},
+	}})
+
+	if got := testutil.CollectAndCount(dump1090ElevationAngle); got != 2 {
+		t.Errorf("got %d elevation angles, want 2 for the aircraft with an altitude", got)
+	}
+	elevation := testutil.ToFloat64(dump1090ElevationAngle.WithLabelValues("", "c00002"))
+	if elevation < 2.5 || elevation > 3 {
+		t.Errorf("got elevation %f for c00002", elevation)
+	}
+
+	// c00001 is 111 km out at 35,000 ft, about a quarter of its horizon. The
+	// antenna is 10 m above ground 990 m above sea level, so the aircraft is
+	// 990 m lower above the terrain than above sea level.
+	ratio := testutil.ToFloat64(dump1090HorizonRatioDirection.WithLabelValues("N", "0", "latest"))
+	horizon := radioHorizon(10, 35000*0.3048-990)
+	if want := distance(51, -114, 52, -114) / horizon; math.Abs(ratio-want) > 1e-9 {
+		t.Errorf("got horizon ratio %f, want %f", ratio, want)
+	}
+}
@@ -46,6 +46,160 @@ var (
 	},
 		[]string{"flight", "hex"},
 	)
+	dump1090Track = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "track",
+		Help:      "Track over ground in degrees.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090Ias = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "ias",
+		Help:      "Indicated Air Speed.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090Tas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "tas",
+		Help:      "True Air Speed.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090Mach = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "mach",
+		Help:      "Mach number.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090NavAltitudeMcp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "nav_altitude_mcp",
+		Help:      "Selected altitude from the Mode Control Panel.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090NavQnh = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "nav_qnh",
+		Help:      "Altimeter setting (QFE or QNH/QNE) in hPa.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090OnGround = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "on_ground",
+		Help:      "Whether the aircraft reports being on the ground.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090Version = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "adsb_version",
+		Help:      "ADS-B version number.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090Nic = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "nic",
+		Help:      "Navigation Integrity Category.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090NacP = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "nac_p",
+		Help:      "Navigation Accuracy for Position.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090Sil = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "sil",
+		Help:      "Source Integrity Level.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090Gva = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "gva",
+		Help:      "Geometric Vertical Accuracy.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090Sda = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "sda",
+		Help:      "System Design Assurance.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090Alert = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "alert",
+		Help:      "Flight status alert bit.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090Spi = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "spi",
+		Help:      "Flight status special position identification bit.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090AircraftStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "aircraft_status",
+		Help:      "Aircraft identity and data sources, always 1.",
+	},
+		[]string{"flight", "hex", "squawk", "type", "category", "nav_modes", "tisb", "country", "military"},
+	)
+	dump1090AircraftByCountry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "aircraft_by_country",
+		Help:      "Aircraft in the latest read by country of registration, from the ICAO address allocation.",
+	},
+		[]string{"country", "military"},
+	)
+	dump1090DistinctAircraft = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "distinct_aircraft",
+		Help:      "Number of different aircraft heard over the last 1h, 24h or today.",
+	},
+		[]string{"time_period"},
+	)
+	dump1090ZoneAircraft = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "zone_aircraft",
+		Help:      "Aircraft in the latest read inside each configured zone.",
+	},
+		[]string{"zone"},
+	)
+	dump1090EmergencyAircraft = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "emergency_aircraft",
+		Help:      "Aircraft currently reporting an emergency, by emergency type.",
+	},
+		[]string{"flight", "hex", "type"},
+	)
+	dump1090AircraftInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "aircraft_info",
+		Help:      "Aircraft database details by hex, always 1.",
+	},
+		[]string{"hex", "registration", "model", "type_code", "manufacturer", "operator", "military", "interesting"},
+	)
+	dump1090AircraftDbAge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "aircraft_db_age_seconds",
+		Help:      "Age of the loaded aircraft database file, 0 until one is loaded.",
+	},
+		dbAge,
+	)
 	dump1090Messages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "dump1090",
 		Name:      "messages_total",
@@ -60,26 +214,68 @@ var (
 	},
 		[]string{"flight", "hex"},
 	)
+	dump1090SlantRange = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "slant_range",
+		Help:      "Straight line distance from the receiver antenna.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090ElevationAngle = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "elevation_angle",
+		Help:      "Angle of the aircraft above the receiver's horizon in degrees.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090RadioHorizon = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "radio_horizon",
+		Help:      "Theoretical radio horizon range for the aircraft's altitude, from the antenna height above the surrounding ground.",
+	},
+		[]string{"flight", "hex"},
+	)
+	dump1090HorizonRatioDirection = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "recent_aircraft_horizon_ratio_by_direction",
+		Help:      "Highest ratio of distance to theoretical radio horizon by direction.",
+	},
+		[]string{"direction", "bearing", "time_period"},
+	)
 	dump1090MaxRangeDirection = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "dump1090",
 		Name:      "recent_aircraft_max_range_by_direction",
-		Help:      "Max distance by direction.",
+		Help:      "Max distance by direction, with the aircraft that set it.",
 	},
-		[]string{"direction", "time_period"},
+		[]string{"direction", "bearing", "time_period", "hex", "flight"},
+	)
+	dump1090MaxRangeAltitude = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "recent_aircraft_max_range_by_altitude",
+		Help:      "Max distance by altitude band and direction.",
+	},
+		[]string{"altitude_band", "direction", "bearing", "time_period"},
+	)
+	dump1090CountByAltitude = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dump1090",
+		Name:      "recent_aircraft_with_altitude",
+		Help:      "Aircraft count by altitude band and direction.",
+	},
+		[]string{"altitude_band", "direction", "bearing", "time_period"},
 	)
 	dump1090MaxRange = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "dump1090",
 		Name:      "recent_aircraft_max_range",
-		Help:      "Maximum range of recently observed aircraft.",
+		Help:      "Maximum range of recently observed aircraft, with the aircraft that set it.",
 	},
-		[]string{"time_period"},
+		[]string{"time_period", "hex", "flight"},
 	)
 	dump1090CountByDirection = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "dump1090",
 		Name:      "recent_aircraft_with_direction",
 		Help:      "Aircraft count by direction.",
 	},
-		[]string{"direction", "time_period"},
+		[]string{"direction", "bearing", "time_period"},
 	)
 	dump1090CountWithPos = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: "dump1090",
@@ -100,6 +296,33 @@ var (
 var opsMetrics struct {
 	AirCraftFileReads func() prometheus.Counter `name:"aircraft_file_reads" help:"Number of reads on the aircraft file"`
 	StatsFileReads    func() prometheus.Counter `name:"stats_file_reads" help:"Number of reads on the stats file"`
+
+	EmergencyEvents        func(emergencyLabels) prometheus.Counter `name:"emergency_events" help:"Number of emergencies that started or ended"`
+	EmergencyWebhookErrors func() prometheus.Counter                `name:"emergency_webhook_errors" help:"Number of emergency webhooks that could not be delivered"`
+
+	ReceiverLocationKnown func() prometheus.Gauge `name:"receiver_location_known" help:"Whether the receiver location is known, from receiver.json or -lat and -lon"`
+
+	AircraftSessions        func() prometheus.Counter   `name:"aircraft_sessions_total" help:"Number of aircraft visits that started"`
+	AircraftSessionDuration func() prometheus.Histogram `name:"aircraft_session_duration_seconds" help:"Duration of aircraft visits from first to last message" buckets:"60,300,600,1200,1800,3600,7200,14400"`
+
+	FlightLogErrors func() prometheus.Counter `name:"flight_log_errors" help:"Number of failed writes to the flight log"`
+
+	ZoneEvents func(zoneLabels) prometheus.Counter `name:"zone_events" help:"Number of aircraft that entered or left each zone"`
+
+	AircraftDbRows           func(dbRowLabels) prometheus.Gauge      `name:"aircraft_db_rows" help:"Number of rows in the last aircraft database load by result"`
+	AircraftDbReloadTime     func() prometheus.Gauge                 `name:"aircraft_db_last_reload_timestamp_seconds" help:"Time of the last successful aircraft database load"`
+	AircraftDbReloadFailures func() prometheus.Counter               `name:"aircraft_db_reload_failures" help:"Number of aircraft database loads that failed"`
+	AircraftDbLookups        func(dbLookupLabels) prometheus.Counter `name:"aircraft_db_lookups" help:"Number of aircraft database lookups by result and whether a cached miss answered them"`
+
+	FileAge    func(fileLabels) prometheus.Gauge   `name:"file_age_seconds" help:"Age of a json file when it was last read"`
+	FileEvents func(fileLabels) prometheus.Counter `name:"file_watch_events" help:"Number of change notifications received for a json file"`
+
+	StreamConnects func(streamLabels) prometheus.Counter        `name:"stream_connects" help:"Number of connections made to a network feed"`
+	StreamErrors   func(streamLabels) prometheus.Counter        `name:"stream_errors" help:"Number of connection and parse errors on a network feed"`
+	StreamMessages func(streamMessageLabels) prometheus.Counter `name:"stream_messages" help:"Number of messages read from a network feed by type"`
+
+	ModeSFrames    func(modesFrameLabels) prometheus.Counter `name:"modes_frames" help:"Number of Mode S frames received by downlink format"`
+	ModeSCRCErrors func(streamLabels) prometheus.Counter     `name:"modes_crc_errors" help:"Number of Mode S frames that failed the CRC check"`
 }
 
 var metrics struct {
@@ -158,6 +381,43 @@ type statLabels struct {
 	TimePeriod string `label:"time_period"`
 }
 
+type dbRowLabels struct {
+	Result string `label:"result"`
+}
+
+type dbLookupLabels struct {
+	Result string `label:"result"`
+	Cached string `label:"cached"`
+}
+
+type zoneLabels struct {
+	Zone  string `label:"zone"`
+	Event string `label:"event"`
+}
+
+type emergencyLabels struct {
+	Event string `label:"event"`
+	Type  string `label:"type"`
+}
+
+type fileLabels struct {
+	File string `label:"file"`
+}
+
+type streamLabels struct {
+	Source string `label:"source"`
+}
+
+type streamMessageLabels struct {
+	Source string `label:"source"`
+	Type   string `label:"type"`
+}
+
+type modesFrameLabels struct {
+	Source string `label:"source"`
+	DF     string `label:"df"`
+}
+
 type requestLabels struct {
 	Flight string `label:"flight"`
 	Hex    string `label:"hex"`
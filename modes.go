@@ -0,0 +1,622 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	modesShortLen = 7
+	modesLongLen  = 14
+
+	// cprPairWindow is the longest gap between an even and odd position
+	// message that can still be combined into a global CPR fix.
+	cprPairWindow = 10 * time.Second
+	// cprLocalWindow is how recent a previous position must be to be used
+	// as the reference for a local CPR decode.
+	cprLocalWindow = 60 * time.Second
+	cprMax         = 131072.0
+)
+
+var (
+	errModeSCRC            = errors.New("mode s crc mismatch")
+	errModeSUnknownAddress = errors.New("mode s address not seen before")
+	errModeSLength         = errors.New("mode s frame has wrong length")
+)
+
+var modesCRCTable [256]uint32
+
+// modesEmergencyStates are the aircraft.json names of the emergency states
+// in an extended squitter aircraft status message.
+var modesEmergencyStates = [8]string{"none", "general", "lifeguard", "minfuel", "nordo", "unlawful", "downed", "reserved"}
+
+var callsignCharset = "#ABCDEFGHIJKLMNOPQRSTUVWXYZ##### ###############0123456789######"
+
+type cprFrame struct {
+	lat int
+	lon int
+	at  time.Time
+}
+
+// modesMessage holds the fields decoded from a single Mode S frame. Only the
+// fields flagged as present are applied to the aircraft.
+type modesMessage struct {
+	DF   int
+	Hex  string
+	Kind string
+
+	// addressParity is set when the address was recovered from the parity
+	// field and can only be trusted for aircraft we already know about.
+	addressParity bool
+
+	Callsign string
+	Category string
+
+	// AddrType is the aircraft.json "type" for the source of the message.
+	AddrType string
+
+	Altitude     int
+	HasAltitude  bool
+	AltitudeGeom bool
+	OnGround     bool
+
+	Squawk    string
+	Emergency string
+
+	CPROdd bool
+	CPRLat int
+	CPRLon int
+	HasCPR bool
+
+	GroundSpeed float64
+	Track       float64
+	HasVelocity bool
+
+	Airspeed    float64
+	AirspeedTAS bool
+	HasAirspeed bool
+
+	VerticalRate     int
+	VerticalRateBaro bool
+	HasVerticalRate  bool
+
+	Signal    float64
+	HasSignal bool
+	Mlat      bool
+}
+
+func init() {
+	for i := 0; i < 256; i++ {
+		c := uint32(i) << 16
+		for j := 0; j < 8; j++ {
+			if c&0x800000 != 0 {
+				c = (c << 1) ^ 0xFFF409
+			} else {
+				c <<= 1
+			}
+		}
+		modesCRCTable[i] = c & 0xFFFFFF
+	}
+}
+
+// modesChecksum computes the 24 bit Mode S parity over data.
+func modesChecksum(data []byte) uint32 {
+	var rem uint32
+	for _, b := range data {
+		rem = ((rem << 8) ^ modesCRCTable[byte(rem>>16)^b]) & 0xFFFFFF
+	}
+	return rem
+}
+
+// modesResidual is the checksum XOR the transmitted parity. It is zero for
+// an intact DF17/18 frame and the ICAO address for address/parity formats.
+func modesResidual(msg []byte) uint32 {
+	n := len(msg)
+	parity := uint32(msg[n-3])<<16 | uint32(msg[n-2])<<8 | uint32(msg[n-1])
+	return modesChecksum(msg[:n-3]) ^ parity
+}
+
+// modesMessageLen returns the frame length in bytes for a downlink format.
+func modesMessageLen(df int) int {
+	if df >= 16 {
+		return modesLongLen
+	}
+	return modesShortLen
+}
+
+// decodeModeS decodes the downlink formats the exporter cares about:
+// DF4/5/20/21 surveillance replies, DF11 all-call and DF17/18 extended
+// squitter.
+func decodeModeS(msg []byte) (modesMessage, error) {
+	if len(msg) != modesShortLen && len(msg) != modesLongLen {
+		return modesMessage{}, errModeSLength
+	}
+
+	df := int(msg[0] >> 3)
+	if df > 24 {
+		df = 24
+	}
+	if len(msg) != modesMessageLen(df) {
+		return modesMessage{}, errModeSLength
+	}
+
+	m := modesMessage{DF: df, Kind: fmt.Sprintf("df%d", df)}
+
+	switch df {
+	case 4, 20:
+		m.Hex = fmt.Sprintf("%06x", modesResidual(msg))
+		m.addressParity = true
+		m.AddrType = "mode_s"
+		m.Kind = fmt.Sprintf("df%d_altitude", df)
+		m.Altitude, m.HasAltitude = decodeAC13(int(msg[2]&0x1F)<<8 | int(msg[3]))
+		m.OnGround = modesFlightStatusGround(msg)
+	case 5, 21:
+		m.Hex = fmt.Sprintf("%06x", modesResidual(msg))
+		m.addressParity = true
+		m.AddrType = "mode_s"
+		m.Kind = fmt.Sprintf("df%d_identity", df)
+		m.Squawk = fmt.Sprintf("%04x", decodeID13(int(msg[2]&0x1F)<<8|int(msg[3])))
+		m.OnGround = modesFlightStatusGround(msg)
+	case 11:
+		// The low 7 bits of the residual carry the interrogator code.
+		if modesResidual(msg)&^0x7F != 0 {
+			return m, errModeSCRC
+		}
+		m.Hex = fmt.Sprintf("%06x", uint32(msg[1])<<16|uint32(msg[2])<<8|uint32(msg[3]))
+		m.AddrType = "mode_s"
+		m.Kind = "df11_all_call"
+	case 17, 18:
+		if modesResidual(msg) != 0 {
+			return m, errModeSCRC
+		}
+		addr := fmt.Sprintf("%06x", uint32(msg[1])<<16|uint32(msg[2])<<8|uint32(msg[3]))
+		m.AddrType = "adsb_icao"
+		if df == 18 {
+			switch msg[0] & 0x07 {
+			case 0:
+				m.AddrType = "adsb_icao_nt"
+			case 1:
+				// Non-ICAO addresses, marked the way dump1090 does.
+				addr = "~" + addr
+				m.AddrType = "adsb_other"
+			case 2:
+				m.AddrType = "tisb_icao"
+			case 5:
+				addr = "~" + addr
+				m.AddrType = "tisb_other"
+			case 6:
+				m.AddrType = "adsr_icao"
+			default:
+				m.Kind = "df18_other"
+				return m, nil
+			}
+		}
+		m.Hex = addr
+		decodeExtendedSquitter(&m, msg[4:11])
+	default:
+		m.Kind = "other"
+	}
+
+	return m, nil
+}
+
+// decodeExtendedSquitter decodes the 56 bit ME field of a DF17/18 frame.
+func decodeExtendedSquitter(m *modesMessage, me []byte) {
+	tc := int(me[0] >> 3)
+	prefix := fmt.Sprintf("df%d_", m.DF)
+
+	switch {
+	case tc >= 1 && tc <= 4:
+		m.Kind = prefix + "identification"
+		m.Category = fmt.Sprintf("%c%d", 'A'+(4-tc), me[0]&0x07)
+		chars := []byte{
+			me[1] >> 2,
+			(me[1]&0x03)<<4 | me[2]>>4,
+			(me[2]&0x0F)<<2 | me[3]>>6,
+			me[3] & 0x3F,
+			me[4] >> 2,
+			(me[4]&0x03)<<4 | me[5]>>4,
+			(me[5]&0x0F)<<2 | me[6]>>6,
+			me[6] & 0x3F,
+		}
+		var callsign strings.Builder
+		for _, c := range chars {
+			callsign.WriteByte(callsignCharset[c])
+		}
+		m.Callsign = strings.TrimRight(strings.ReplaceAll(callsign.String(), "#", ""), " ")
+	case tc >= 5 && tc <= 8:
+		m.Kind = prefix + "surface_position"
+		m.OnGround = true
+	case (tc >= 9 && tc <= 18) || (tc >= 20 && tc <= 22):
+		m.Kind = prefix + "airborne_position"
+		m.Altitude, m.HasAltitude = decodeAC12(int(me[1])<<4 | int(me[2]>>4))
+		m.AltitudeGeom = tc >= 20
+		m.CPROdd = me[2]&0x04 != 0
+		m.CPRLat = int(me[2]&0x03)<<15 | int(me[3])<<7 | int(me[4]>>1)
+		m.CPRLon = int(me[4]&0x01)<<16 | int(me[5])<<8 | int(me[6])
+		m.HasCPR = true
+	case tc == 28 && me[0]&0x07 == 1:
+		m.Kind = prefix + "emergency"
+		m.Emergency = modesEmergencyStates[me[1]>>5]
+		m.Squawk = fmt.Sprintf("%04x", decodeID13(int(me[1]&0x1F)<<8|int(me[2])))
+	case tc == 19:
+		m.Kind = prefix + "velocity"
+		subtype := me[0] & 0x07
+		if subtype == 1 || subtype == 2 {
+			ewRaw := int(me[1]&0x03)<<8 | int(me[2])
+			nsRaw := int(me[3]&0x7F)<<3 | int(me[4]>>5)
+			if ewRaw != 0 && nsRaw != 0 {
+				scale := 1
+				if subtype == 2 {
+					scale = 4
+				}
+				vx := float64((ewRaw - 1) * scale)
+				vy := float64((nsRaw - 1) * scale)
+				if me[1]&0x04 != 0 {
+					vx = -vx
+				}
+				if me[3]&0x80 != 0 {
+					vy = -vy
+				}
+				m.GroundSpeed = math.Hypot(vx, vy)
+				m.Track = math.Mod(math.Atan2(vx, vy)*180/math.Pi+360, 360)
+				m.HasVelocity = true
+			}
+		}
+		if subtype == 3 || subtype == 4 {
+			if raw := int(me[3]&0x7F)<<3 | int(me[4]>>5); raw != 0 {
+				scale := 1
+				if subtype == 4 {
+					scale = 4
+				}
+				m.Airspeed = float64((raw - 1) * scale)
+				m.AirspeedTAS = me[3]&0x80 != 0
+				m.HasAirspeed = true
+			}
+		}
+		if vr := int(me[4]&0x07)<<6 | int(me[5]>>2); vr != 0 {
+			m.VerticalRate = (vr - 1) * 64
+			if me[4]&0x08 != 0 {
+				m.VerticalRate = -m.VerticalRate
+			}
+			m.VerticalRateBaro = me[4]&0x10 != 0
+			m.HasVerticalRate = true
+		}
+	default:
+		m.Kind = prefix + "other"
+	}
+}
+
+// modesFlightStatusGround reports whether the flight status of a DF4/5/20/21
+// reply says the aircraft is on the ground.
+func modesFlightStatusGround(msg []byte) bool {
+	fs := msg[0] & 0x07
+	return fs == 1 || fs == 3
+}
+
+// decodeID13 reorders the 13 bit identity field into the 0xABCD Gillham
+// layout, which printed as hex is the squawk.
+func decodeID13(id13 int) int {
+	gillham := 0
+	bits := []struct{ from, to int }{
+		{0x1000, 0x0010}, // C1
+		{0x0800, 0x1000}, // A1
+		{0x0400, 0x0020}, // C2
+		{0x0200, 0x2000}, // A2
+		{0x0100, 0x0040}, // C4
+		{0x0080, 0x4000}, // A4
+		{0x0020, 0x0100}, // B1
+		{0x0010, 0x0001}, // D1
+		{0x0008, 0x0200}, // B2
+		{0x0004, 0x0002}, // D2
+		{0x0002, 0x0400}, // B4
+		{0x0001, 0x0004}, // D4
+	}
+	for _, b := range bits {
+		if id13&b.from != 0 {
+			gillham |= b.to
+		}
+	}
+	return gillham
+}
+
+// modeAToModeC converts a Gillham coded altitude into feet.
+func modeAToModeC(modeA int) (int, bool) {
+	if modeA&0xFFFF8889 != 0 || modeA&0x00F0 == 0 {
+		return 0, false
+	}
+
+	oneHundreds := 0
+	if modeA&0x0010 != 0 {
+		oneHundreds ^= 0x007
+	}
+	if modeA&0x0020 != 0 {
+		oneHundreds ^= 0x003
+	}
+	if modeA&0x0040 != 0 {
+		oneHundreds ^= 0x001
+	}
+	if oneHundreds&5 == 5 {
+		oneHundreds ^= 2
+	}
+	if oneHundreds > 5 {
+		return 0, false
+	}
+
+	fiveHundreds := 0
+	for _, b := range []struct{ bit, mask int }{
+		{0x0002, 0x0FF}, // D2
+		{0x0004, 0x07F}, // D4
+		{0x1000, 0x03F}, // A1
+		{0x2000, 0x01F}, // A2
+		{0x4000, 0x00F}, // A4
+		{0x0100, 0x007}, // B1
+		{0x0200, 0x003}, // B2
+		{0x0400, 0x001}, // B4
+	} {
+		if modeA&b.bit != 0 {
+			fiveHundreds ^= b.mask
+		}
+	}
+	if fiveHundreds&1 != 0 {
+		oneHundreds = 6 - oneHundreds
+	}
+
+	return (fiveHundreds*5 + oneHundreds - 13) * 100, true
+}
+
+// decodeAC13 decodes the 13 bit altitude field of DF0/4/16/20.
+func decodeAC13(ac13 int) (int, bool) {
+	if ac13 == 0 {
+		return 0, false
+	}
+	if ac13&0x0040 != 0 {
+		// M bit set, altitude reported in metres.
+		n := (ac13&0x1F80)>>1 | ac13&0x003F
+		return int(float64(n) * 3.28084), true
+	}
+	if ac13&0x0010 != 0 {
+		// Q bit set, 25ft increments.
+		n := (ac13&0x1F80)>>2 | (ac13&0x0020)>>1 | ac13&0x000F
+		return n*25 - 1000, true
+	}
+	return modeAToModeC(decodeID13(ac13))
+}
+
+// decodeAC12 decodes the 12 bit altitude field of an airborne position.
+func decodeAC12(ac12 int) (int, bool) {
+	ac12 &= 0x0FFF
+	if ac12 == 0 {
+		return 0, false
+	}
+	if ac12&0x0010 != 0 {
+		n := (ac12&0x0FE0)>>1 | ac12&0x000F
+		return n*25 - 1000, true
+	}
+	return modeAToModeC(decodeID13((ac12&0x0FC0)<<1 | ac12&0x003F))
+}
+
+// cprNL returns the number of longitude zones at a given latitude.
+func cprNL(lat float64) int {
+	lat = math.Abs(lat)
+	if lat == 0 {
+		return 59
+	}
+	if lat == 87 {
+		return 2
+	}
+	if lat > 87 {
+		return 1
+	}
+	a := 1 - math.Cos(math.Pi/(2*15))
+	b := math.Pow(math.Cos(math.Pi/180*lat), 2)
+	return int(math.Floor(2 * math.Pi / math.Acos(1-a/b)))
+}
+
+func cprMod(a float64, b float64) float64 {
+	r := math.Mod(a, b)
+	if r < 0 {
+		r += b
+	}
+	return r
+}
+
+// cprGlobal resolves an airborne position from an even/odd pair. The newest
+// frame decides which of the two latitudes is reported.
+func cprGlobal(even cprFrame, odd cprFrame, oddNewest bool) (float64, float64, bool) {
+	latEven := float64(even.lat) / cprMax
+	lonEven := float64(even.lon) / cprMax
+	latOdd := float64(odd.lat) / cprMax
+	lonOdd := float64(odd.lon) / cprMax
+
+	j := math.Floor(59*latEven - 60*latOdd + 0.5)
+	rlatEven := 360.0 / 60 * (cprMod(j, 60) + latEven)
+	rlatOdd := 360.0 / 59 * (cprMod(j, 59) + latOdd)
+	if rlatEven >= 270 {
+		rlatEven -= 360
+	}
+	if rlatOdd >= 270 {
+		rlatOdd -= 360
+	}
+	if rlatEven < -90 || rlatEven > 90 || rlatOdd < -90 || rlatOdd > 90 {
+		return 0, 0, false
+	}
+	if cprNL(rlatEven) != cprNL(rlatOdd) {
+		// The pair straddles a longitude zone boundary.
+		return 0, 0, false
+	}
+
+	lat := rlatEven
+	cprLon := lonEven
+	nl := cprNL(lat)
+	ni := nl
+	if oddNewest {
+		lat = rlatOdd
+		cprLon = lonOdd
+		ni = nl - 1
+	}
+	if ni < 1 {
+		ni = 1
+	}
+	m := math.Floor(lonEven*float64(nl-1) - lonOdd*float64(nl) + 0.5)
+	lon := 360.0 / float64(ni) * (cprMod(m, float64(ni)) + cprLon)
+	if lon > 180 {
+		lon -= 360
+	}
+
+	return lat, lon, true
+}
+
+// cprLocal resolves an airborne position from a single frame using a nearby
+// reference position.
+func cprLocal(refLat float64, refLon float64, frame cprFrame, odd bool) (float64, float64) {
+	i := 0.0
+	if odd {
+		i = 1
+	}
+	cprLat := float64(frame.lat) / cprMax
+	cprLon := float64(frame.lon) / cprMax
+
+	dLat := 360.0 / (60 - i)
+	j := math.Floor(refLat/dLat) + math.Floor(0.5+cprMod(refLat, dLat)/dLat-cprLat)
+	lat := dLat * (j + cprLat)
+
+	dLon := 360.0
+	if ni := float64(cprNL(lat)) - i; ni > 0 {
+		dLon = 360.0 / ni
+	}
+	m := math.Floor(refLon/dLon) + math.Floor(0.5+cprMod(refLon, dLon)/dLon-cprLon)
+	lon := dLon * (m + cprLon)
+
+	return lat, lon
+}
+
+// apply copies the decoded fields onto the aircraft, resolving CPR
+// positions against the aircraft's previous frames.
+func (m modesMessage) apply(a *trackedAircraft, now time.Time) {
+
+	if m.Callsign != "" {
+		a.Flight = m.Callsign
+	}
+	if m.Category != "" {
+		a.Category = m.Category
+	}
+	if m.AddrType != "" && (m.AddrType != "mode_s" || a.Type == "") {
+		a.Type = m.AddrType
+	}
+	if m.HasAltitude {
+		if m.AltitudeGeom {
+			a.AltoGeom = Altitude{Feet: m.Altitude}
+		} else {
+			a.AltoBaro = Altitude{Feet: m.Altitude}
+		}
+	}
+	if m.OnGround {
+		a.AltoBaro = Altitude{Ground: true}
+	}
+	if m.Squawk != "" {
+		a.Squawk = m.Squawk
+	}
+	if m.Emergency != "" {
+		a.Emergency = m.Emergency
+	}
+	if m.HasVelocity {
+		track := m.Track
+		a.GroundSpeed = m.GroundSpeed
+		a.Track = &track
+	}
+	if m.HasAirspeed {
+		speed := m.Airspeed
+		if m.AirspeedTAS {
+			a.TAS = &speed
+		} else {
+			a.IAS = &speed
+		}
+	}
+	if m.HasVerticalRate && m.VerticalRateBaro {
+		a.BaroRate = int16(m.VerticalRate)
+	}
+	if m.HasSignal {
+		a.RSSi = m.Signal
+	}
+
+	if m.HasCPR {
+		frame := cprFrame{lat: m.CPRLat, lon: m.CPRLon, at: now}
+		other := a.cpr[0]
+		if m.CPROdd {
+			a.cpr[1] = frame
+		} else {
+			a.cpr[0] = frame
+			other = a.cpr[1]
+		}
+
+		var lat, lon float64
+		ok := false
+		if !other.at.IsZero() && now.Sub(other.at) <= cprPairWindow {
+			lat, lon, ok = cprGlobal(a.cpr[0], a.cpr[1], m.CPROdd)
+		}
+		if !ok && !a.lastPos.IsZero() && now.Sub(a.lastPos) <= cprLocalWindow {
+			lat, lon = cprLocal(a.Latitude, a.Longitude, frame, m.CPROdd)
+			ok = true
+		}
+		if ok {
+			a.Latitude = lat
+			a.Longitude = lon
+			a.lastPos = now
+			if m.Mlat {
+				a.Type = "mlat"
+				a.Mlat = []string{"lat", "lon"}
+			} else {
+				a.Mlat = nil
+			}
+		}
+	}
+}
+
+// applyModeS applies a decoded message to the table. Messages whose address
+// came from the parity field are only accepted for aircraft that have
+// already been heard with a checked address, as dump1090 does.
+func (t *aircraftTable) applyModeS(m modesMessage, now time.Time) error {
+	if m.Hex == "" {
+		return nil
+	}
+	if m.addressParity && !t.known(m.Hex) {
+		return errModeSUnknownAddress
+	}
+
+	t.update(m.Hex, now, func(a *trackedAircraft) {
+		m.apply(a, now)
+	})
+	return nil
+}
+
+// handleModeS decodes one raw frame read from a feed, counts it by downlink
+// format and applies it to the aircraft table. annotate fills in receiver
+// side details such as the signal level before the message is applied.
+func (t *aircraftTable) handleModeS(source string, data []byte, annotate func(m *modesMessage)) (modesMessage, error) {
+
+	msg, err := decodeModeS(data)
+	if err == nil || err == errModeSCRC {
+		opsMetrics.ModeSFrames(modesFrameLabels{Source: source, DF: strconv.Itoa(msg.DF)}).Inc()
+	}
+	if err == errModeSCRC {
+		opsMetrics.ModeSCRCErrors(streamLabels{Source: source}).Inc()
+	}
+	if err != nil {
+		return msg, err
+	}
+
+	if annotate != nil {
+		annotate(&msg)
+	}
+	if err := t.applyModeS(msg, time.Now()); err != nil {
+		return msg, err
+	}
+
+	opsMetrics.StreamMessages(streamMessageLabels{Source: source, Type: msg.Kind}).Inc()
+	return msg, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"math"
+	"testing"
+	"time"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeModeSIdentification(t *testing.T) {
+	msg, err := decodeModeS(mustHex(t, "8D4840D6202CC371C32CE0576098"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Hex != "4840d6" || msg.Callsign != "KLM1023" || msg.Category != "A0" || msg.Kind != "df17_identification" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+}
+
+func TestDecodeModeSCRC(t *testing.T) {
+	if _, err := decodeModeS(mustHex(t, "8D4840D6202CC371C32CE0576099")); err != errModeSCRC {
+		t.Errorf("got %v, want %v", err, errModeSCRC)
+	}
+}
+
+func TestDecodeModeSVelocity(t *testing.T) {
+	msg, err := decodeModeS(mustHex(t, "8D485020994409940838175B284F"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(msg.GroundSpeed-159.20) > 0.01 || math.Abs(msg.Track-182.88) > 0.01 {
+		t.Errorf("got gs %f track %f, want 159.20 182.88", msg.GroundSpeed, msg.Track)
+	}
+	if msg.VerticalRate != -832 || msg.VerticalRateBaro {
+		t.Errorf("got vertical rate %d baro %t, want -832 false", msg.VerticalRate, msg.VerticalRateBaro)
+	}
+}
+
+func TestDecodeModeSGlobalPosition(t *testing.T) {
+	table := newAircraftTable()
+	now := time.Now()
+
+	for i, frame := range []string{"8D40621D58C386435CC412692AD6", "8D40621D58C382D690C8AC2863A7"} {
+		msg, err := decodeModeS(mustHex(t, frame))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := table.applyModeS(msg, now.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := table.snapshot(now.Add(time.Second))
+	if len(list.Aircraft) != 1 {
+		t.Fatalf("got %d aircraft, want 1", len(list.Aircraft))
+	}
+	a := list.Aircraft[0]
+	if math.Abs(a.Latitude-52.2572) > 0.0001 || math.Abs(a.Longitude-3.91937) > 0.0001 {
+		t.Errorf("got position %f,%f, want 52.2572,3.91937", a.Latitude, a.Longitude)
+	}
+	if a.AltoBaro.Feet != 38000 {
+		t.Errorf("got altitude %d, want 38000", a.AltoBaro.Feet)
+	}
+}
+
+func TestDecodeAC13(t *testing.T) {
+	var tests = []struct {
+		ac13 int
+		want int
+	}{
+		{0x1838, 38000}, // Q bit set
+		{0x0000, 0},
+	}
+
+	for _, tt := range tests {
+		got, _ := decodeAC13(tt.ac13)
+		if got != tt.want {
+			t.Errorf("decodeAC13(%#x) got %d, want %d", tt.ac13, got, tt.want)
+		}
+	}
+}
+
+func TestReadBeastFrame(t *testing.T) {
+	payload := mustHex(t, "8D4840D6202CC371C32CE0576098")
+	var stream bytes.Buffer
+	// Leading garbage, then a long frame whose timestamp contains an
+	// escaped 0x1a.
+	stream.Write([]byte{0x00, 0x42})
+	stream.Write([]byte{beastEscape, beastModeSLong, 0x00, 0x00, 0x1a, 0x1a, 0x00, 0x01, 0x00, 0x80})
+	stream.Write(payload)
+
+	frame, err := readBeastFrame(bufio.NewReader(&stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Timestamp != 0x1a000100 || frame.Signal != 0x80 || !bytes.Equal(frame.Data, payload) {
+		t.Errorf("unexpected frame %+v", frame)
+	}
+}
+
+func TestParseAVR(t *testing.T) {
+	var tests = []struct {
+		line      string
+		wantErr   bool
+		timestamp uint64
+	}{
+		{"*8D4840D6202CC371C32CE0576098;", false, 0},
+		{"@0000123456788D4840D6202CC371C32CE0576098;", false, 0x12345678},
+		{"*5D4840D6A1B2C3;", false, 0},
+		{"*8D4840D6202CC371C32CE0576098", true, 0},
+		{"*8D4840D6;", true, 0},
+		{"#8D4840D6202CC371C32CE0576098;", true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			frame, err := parseAVR(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && frame.Timestamp != tt.timestamp {
+				t.Errorf("got timestamp %x, want %x", frame.Timestamp, tt.timestamp)
+			}
+		})
+	}
+}
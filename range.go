@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rangePeriods are the rolling windows max range is kept for. A zero window
+// is all-time.
+var rangePeriods = []struct {
+	name   string
+	window time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"all", 0},
+}
+
+// rangeHistoryWindow is the longest rolling window.
+const rangeHistoryWindow = 7 * 24 * time.Hour
+
+var rangeRecords = newRangeHistory()
+
+// rangeRecord is the furthest position seen in a sector at some time.
+type rangeRecord struct {
+	Time     time.Time `json:"time"`
+	Distance float64   `json:"distance"`
+	Angle    float64   `json:"angle"`
+	Hex      string    `json:"hex"`
+	Flight   string    `json:"flight,omitempty"`
+}
+
+func (r rangeRecord) labels(timePeriod string) prometheus.Labels {
+	return prometheus.Labels{"time_period": timePeriod, "hex": r.Hex, "flight": r.Flight}
+}
+
+// sectorHistory keeps every record that can still be the maximum of some
+// rolling window: distances decrease and times increase along recent, since a
+// record beaten by a later one can never be a window's maximum again. The
+// maximum of the last w is then the first record younger than w.
+type sectorHistory struct {
+	recent  []rangeRecord
+	allTime rangeRecord
+}
+
+func (h *sectorHistory) observe(r rangeRecord) {
+	for len(h.recent) > 0 && h.recent[len(h.recent)-1].Distance <= r.Distance {
+		h.recent = h.recent[:len(h.recent)-1]
+	}
+	// Keep at most one record a minute. A shorter record within a minute
+	// of a longer one would only outlive it by seconds.
+	if n := len(h.recent); n == 0 || r.Time.Sub(h.recent[n-1].Time) >= time.Minute {
+		h.recent = append(h.recent, r)
+	}
+	if r.Distance > h.allTime.Distance {
+		h.allTime = r
+	}
+}
+
+func (h *sectorHistory) expire(now time.Time) {
+	i := 0
+	for i < len(h.recent) && now.Sub(h.recent[i].Time) > rangeHistoryWindow {
+		i++
+	}
+	h.recent = h.recent[i:]
+}
+
+// max returns the furthest record of the last window, or all-time for 0.
+func (h *sectorHistory) max(window time.Duration, now time.Time) (rangeRecord, bool) {
+	if window == 0 {
+		return h.allTime, h.allTime.Distance > 0
+	}
+	for _, r := range h.recent {
+		if now.Sub(r.Time) <= window {
+			return r, true
+		}
+	}
+	return rangeRecord{}, false
+}
+
+// rangeHistory tracks the rolling maximum range of every bearing sector.
+type rangeHistory struct {
+	mu      sync.Mutex
+	sectors map[sector]*sectorHistory
+}
+
+func newRangeHistory() *rangeHistory {
+	return &rangeHistory{sectors: make(map[sector]*sectorHistory)}
+}
+
+// observe adds a record. Records have to be observed in time order.
+func (h *rangeHistory) observe(r rangeRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.observeLocked(r)
+}
+
+func (h *rangeHistory) observeLocked(r rangeRecord) {
+	s := bearingSector(r.Angle)
+	history, ok := h.sectors[s]
+	if !ok {
+		history = &sectorHistory{}
+		h.sectors[s] = history
+	}
+	history.observe(r)
+}
+
+// setMetrics exposes the rolling maximum of every sector and overall.
+func (h *rangeHistory) setMetrics(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, period := range rangePeriods {
+		var overall rangeRecord
+		for s, history := range h.sectors {
+			history.expire(now)
+			r, ok := history.max(period.window, now)
+			if !ok {
+				continue
+			}
+			labels := s.labels(period.name)
+			for k, v := range r.labels(period.name) {
+				labels[k] = v
+			}
+			dump1090MaxRangeDirection.With(labels).Set(r.Distance)
+			if r.Distance > overall.Distance {
+				overall = r
+			}
+		}
+		if overall.Distance > 0 {
+			dump1090MaxRange.With(overall.labels(period.name)).Set(overall.Distance)
+		}
+	}
+}
+
+// rangeFile is the on disk form of a rangeHistory. Records are binned into
+// sectors again on load, so the number of sectors can change across
+// restarts.
+type rangeFile struct {
+	Records []rangeRecord `json:"records"`
+}
+
+// save writes every record to path, replacing it atomically.
+func (h *rangeHistory) save(path string) error {
+	h.mu.Lock()
+	var file rangeFile
+	for _, history := range h.sectors {
+		file.Records = append(file.Records, history.recent...)
+		if history.allTime.Distance > 0 {
+			file.Records = append(file.Records, history.allTime)
+		}
+	}
+	h.mu.Unlock()
+
+	sort.Slice(file.Records, func(i, j int) bool { return file.Records[i].Time.Before(file.Records[j].Time) })
+	return writeJsonFile(path, file)
+}
+
+// load replays the records saved at path. A missing file is not an error.
+func (h *rangeHistory) load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var file rangeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sort.Slice(file.Records, func(i, j int) bool { return file.Records[i].Time.Before(file.Records[j].Time) })
+	for _, r := range file.Records {
+		h.observeLocked(r)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSectorHistory(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var h sectorHistory
+
+	h.observe(rangeRecord{Time: start, Distance: 300, Hex: "a"})
+	h.observe(rangeRecord{Time: start.Add(2 * time.Hour), Distance: 200, Hex: "b"})
+	h.observe(rangeRecord{Time: start.Add(2*time.Hour + 10*time.Second), Distance: 150, Hex: "c"})
+	h.observe(rangeRecord{Time: start.Add(3 * time.Hour), Distance: 100, Hex: "d"})
+
+	now := start.Add(3*time.Hour + time.Minute)
+	var tests = []struct {
+		window time.Duration
+		hex    string
+	}{
+		{time.Hour, "d"},
+		{2 * time.Hour, "b"},
+		{24 * time.Hour, "a"},
+		{0, "a"},
+	}
+	for _, tt := range tests {
+		if r, ok := h.max(tt.window, now); !ok || r.Hex != tt.hex {
+			t.Errorf("max over %s = %+v, want %s", tt.window, r, tt.hex)
+		}
+	}
+
+	// A week later only the all-time record is left.
+	h.expire(start.Add(8 * 24 * time.Hour))
+	if r, ok := h.max(rangeHistoryWindow, start.Add(8*24*time.Hour)); ok {
+		t.Errorf("got %+v after every record expired", r)
+	}
+	if r, ok := h.max(0, start.Add(8*24*time.Hour)); !ok || r.Hex != "a" {
+		t.Errorf("all-time record lost: %+v", r)
+	}
+}
+
+func TestRangeHistoryPersistence(t *testing.T) {
+	now := time.Now()
+	h := newRangeHistory()
+	h.observe(rangeRecord{Time: now.Add(-10 * 24 * time.Hour), Distance: 400000, Angle: 350, Hex: "a0b1c2"})
+	h.observe(rangeRecord{Time: now.Add(-2 * time.Hour), Distance: 300000, Angle: 180, Hex: "c0ffee"})
+	h.observe(rangeRecord{Time: now.Add(-30 * time.Minute), Distance: 250000, Angle: 10, Hex: "c05f0a", Flight: "WJA123"})
+
+	path := filepath.Join(t.TempDir(), "range.json")
+	if err := h.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	rangeRecords = newRangeHistory()
+	defer func() { rangeRecords = newRangeHistory() }()
+	if err := rangeRecords.load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	dump1090MaxRange.Reset()
+	dump1090MaxRangeDirection.Reset()
+	rangeRecords.setMetrics(now)
+
+	var tests = []struct {
+		period string
+		hex    string
+		flight string
+		want   float64
+	}{
+		{"1h", "c05f0a", "WJA123", 250000},
+		{"24h", "c0ffee", "", 300000},
+		{"7d", "c0ffee", "", 300000},
+		{"all", "a0b1c2", "", 400000},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(dump1090MaxRange.WithLabelValues(tt.period, tt.hex, tt.flight)); got != tt.want {
+			t.Errorf("got %f max range over %s, want %f", got, tt.period, tt.want)
+		}
+	}
+	if got := testutil.ToFloat64(dump1090MaxRangeDirection.WithLabelValues("N", "0", "all", "a0b1c2", "")); got != 400000 {
+		t.Errorf("got %f all-time max range to the north, want 400000", got)
+	}
+
+	if err := newRangeHistory().load(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("got %v loading a missing file", err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// bearingSectors is how many equal sectors the direction metrics split the
+// compass into. Each sector is centred on its bearing, so with 8 sectors N
+// covers 337.5° to 22.5°.
+var bearingSectors = 8
+
+// compassPoints names the 16 point compass, clockwise from north.
+var compassPoints = [16]string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+
+// sector is one bearing bin of the direction metrics.
+type sector struct {
+	bearing   float64
+	direction string
+}
+
+// setBearingSectors validates and sets the number of sectors.
+func setBearingSectors(n int) error {
+	switch n {
+	case 8, 16, 36, 72:
+		bearingSectors = n
+		return nil
+	}
+	return fmt.Errorf("unsupported number of sectors %d, use 8, 16, 36 or 72", n)
+}
+
+// sectors returns every sector, clockwise from north.
+func sectors() []sector {
+	list := make([]sector, bearingSectors)
+	for i := range list {
+		list[i] = sectorAt(i)
+	}
+	return list
+}
+
+// bearingSector returns the sector an angle in degrees falls in.
+func bearingSector(angle float64) sector {
+	width := 360 / float64(bearingSectors)
+	index := int(math.Floor(math.Mod(angle+width/2, 360)/width)) % bearingSectors
+	if index < 0 {
+		index += bearingSectors
+	}
+	return sectorAt(index)
+}
+
+func sectorAt(index int) sector {
+	bearing := float64(index) * 360 / float64(bearingSectors)
+
+	// Name 8 sectors on the 8 point compass and finer ones on the 16 point
+	// compass, by the nearest point.
+	points := 16
+	if bearingSectors == 8 {
+		points = 8
+	}
+	point := int(math.Round(bearing/(360/float64(points)))) % points
+
+	return sector{
+		bearing:   bearing,
+		direction: compassPoints[point*16/points],
+	}
+}
+
+func (s sector) labels(timePeriod string) prometheus.Labels {
+	return prometheus.Labels{
+		"direction":   s.direction,
+		"bearing":     strconv.FormatFloat(s.bearing, 'f', -1, 64),
+		"time_period": timePeriod,
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var sessions = newSessionTracker()
+
+// session is one visit of an aircraft, from when it first appears until it
+// has not been heard from for the tracker's timeout.
+type session struct {
+	Hex       string    `json:"hex"`
+	Flight    string    `json:"flight,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// MinDistance is the closest approach in metres, only known when the
+	// aircraft was positioned and the receiver location is known.
+	MinDistance *float64 `json:"min_distance,omitempty"`
+	MaxAltitude *int     `json:"max_altitude,omitempty"`
+	// EntryBearing and ExitBearing are the bearings from the receiver of the
+	// first and last positions of the visit.
+	EntryBearing *float64 `json:"entry_bearing,omitempty"`
+	ExitBearing  *float64 `json:"exit_bearing,omitempty"`
+	// Positions counts the distinct positions reported during the visit.
+	Positions int     `json:"positions"`
+	Messages  float64 `json:"messages"`
+
+	lastPosition [2]float64
+}
+
+// sessionTracker turns the snapshots in aircraft.json into visits. dump1090
+// keeps an aircraft in aircraft.json for a while after its last message, so
+// the last message time comes from Seen rather than from whether it is
+// listed.
+type sessionTracker struct {
+	mu     sync.Mutex
+	active map[string]*session
+
+	// timeout is how long an aircraft has to be silent for its visit to
+	// end. An aircraft heard again after that starts a new visit.
+	timeout time.Duration
+}
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{
+		active:  make(map[string]*session),
+		timeout: 5 * time.Minute,
+	}
+}
+
+// observe updates the visits from one read of aircraft and returns how many
+// started and the visits that ended.
+func (t *sessionTracker) observe(aircraft []Aircraft, now time.Time) (int, []session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	started := 0
+	for _, a := range aircraft {
+		lastSeen := now.Add(-time.Duration(a.Seen * float64(time.Second)))
+		if now.Sub(lastSeen) >= t.timeout {
+			continue
+		}
+
+		s, ok := t.active[a.Hex]
+		if !ok {
+			s = &session{Hex: a.Hex, FirstSeen: lastSeen}
+			t.active[a.Hex] = s
+			started++
+		}
+		s.update(a, lastSeen)
+	}
+
+	var ended []session
+	for hex, s := range t.active {
+		if now.Sub(s.LastSeen) >= t.timeout {
+			delete(t.active, hex)
+			ended = append(ended, *s)
+		}
+	}
+	return started, ended
+}
+
+// sessionFile is the on disk form of a visit in progress. lastPosition is
+// kept so a restored visit does not count its last position again.
+type sessionFile struct {
+	session
+	LastPosition [2]float64 `json:"last_position"`
+}
+
+// save writes the visits in progress to path, replacing it atomically.
+func (t *sessionTracker) save(path string) error {
+	t.mu.Lock()
+	file := make([]sessionFile, 0, len(t.active))
+	for _, s := range t.active {
+		file = append(file, sessionFile{session: *s, LastPosition: s.lastPosition})
+	}
+	t.mu.Unlock()
+
+	return writeJsonFile(path, file)
+}
+
+// load restores the visits in progress saved at path. Visits whose aircraft
+// went silent while the exporter was down end on the next read. A missing
+// file is not an error.
+func (t *sessionTracker) load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var file []sessionFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, f := range file {
+		if _, ok := t.active[f.Hex]; ok {
+			continue
+		}
+		s := f.session
+		s.lastPosition = f.LastPosition
+		t.active[s.Hex] = &s
+	}
+	return nil
+}
+
+func (s *session) update(a Aircraft, lastSeen time.Time) {
+	if lastSeen.After(s.LastSeen) {
+		s.LastSeen = lastSeen
+	}
+	if flight := strings.TrimSpace(a.Flight); flight != "" {
+		s.Flight = flight
+	}
+	// dump1090 counts messages per aircraft for as long as it tracks it.
+	if a.Messages > s.Messages {
+		s.Messages = a.Messages
+	}
+
+	if alt := a.AltoBaro; alt != (Altitude{}) && !alt.Ground {
+		if s.MaxAltitude == nil || alt.Feet > *s.MaxAltitude {
+			feet := alt.Feet
+			s.MaxAltitude = &feet
+		}
+	}
+
+	if a.SeenPos >= positionMaxAge || (a.Latitude == 0 && a.Longitude == 0) {
+		return
+	}
+	// aircraft.json repeats the last position until a new one arrives.
+	if position := [2]float64{a.Latitude, a.Longitude}; position != s.lastPosition {
+		s.lastPosition = position
+		s.Positions++
+	}
+
+	if receiverLocationKnown() {
+		dist := distance(ReceiverLat, ReceiverLon, a.Latitude, a.Longitude)
+		if s.MinDistance == nil || dist < *s.MinDistance {
+			s.MinDistance = &dist
+		}
+		bearing := relativeAngle(ReceiverLat, ReceiverLon, a.Latitude, a.Longitude)
+		if s.EntryBearing == nil {
+			s.EntryBearing = &bearing
+		}
+		s.ExitBearing = &bearing
+	}
+}
+
+// sessionMetrics tracks visits across reads of aircraft.
+func sessionMetrics(aircraft []Aircraft, now time.Time) {
+
+	started, ended := sessions.observe(aircraft, now)
+	opsMetrics.AircraftSessions().Add(float64(started))
+
+	for _, s := range ended {
+		duration := s.LastSeen.Sub(s.FirstSeen)
+		opsMetrics.AircraftSessionDuration().Observe(duration.Seconds())
+		log.Debug().
+			Str("hex", s.Hex).
+			Str("flight", s.Flight).
+			Time("first_seen", s.FirstSeen).
+			Dur("duration", duration).
+			Float64("messages", s.Messages).
+			Msg("Aircraft visit ended")
+
+		if flights != nil {
+			flights.add(newFlight(s))
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSessionTracker(t *testing.T) {
+	ReceiverLat, ReceiverLon = 51, -114
+	defer func() { ReceiverLat, ReceiverLon = 0, 0 }()
+
+	tracker := newSessionTracker()
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	started, ended := tracker.observe([]Aircraft{
+		{Hex: "c05f0a", Flight: "WJA123  ", Seen: 1, Latitude: 51.5, Longitude: -114, AltoBaro: Altitude{Feet: 12000}, Messages: 10},
+		// Still listed by dump1090 but silent for longer than the timeout.
+		{Hex: "c0ffee", Seen: 400},
+	}, now)
+	if started != 1 || len(ended) != 0 {
+		t.Fatalf("got %d started and %d ended, want 1 and 0", started, len(ended))
+	}
+
+	tracker.observe([]Aircraft{
+		{Hex: "c05f0a", Seen: 0, Latitude: 51.1, Longitude: -114, AltoBaro: Altitude{Feet: 5000}, Messages: 250},
+	}, now.Add(10*time.Minute))
+
+	// Listed but silent: the visit ends once it has been quiet for 5 minutes.
+	if _, ended := tracker.observe([]Aircraft{{Hex: "c05f0a", Seen: 240, Messages: 250}}, now.Add(14*time.Minute)); len(ended) != 0 {
+		t.Fatalf("visit ended early: %+v", ended)
+	}
+	_, ended = tracker.observe(nil, now.Add(16*time.Minute))
+	if len(ended) != 1 {
+		t.Fatalf("got %d ended visits, want 1", len(ended))
+	}
+
+	s := ended[0]
+	if s.Hex != "c05f0a" || s.Flight != "WJA123" || s.Messages != 250 {
+		t.Errorf("unexpected visit %+v", s)
+	}
+	if !s.FirstSeen.Equal(now.Add(-time.Second)) || !s.LastSeen.Equal(now.Add(10*time.Minute)) {
+		t.Errorf("got visit from %s to %s", s.FirstSeen, s.LastSeen)
+	}
+	if s.MaxAltitude == nil || *s.MaxAltitude != 12000 {
+		t.Errorf("got max altitude %v, want 12000", s.MaxAltitude)
+	}
+	if s.MinDistance == nil || *s.MinDistance < 11000 || *s.MinDistance > 11200 {
+		t.Errorf("got closest approach %v, want about 11.1 km", s.MinDistance)
+	}
+
+	// Heard from again, it is a new visit.
+	if started, _ := tracker.observe([]Aircraft{{Hex: "c05f0a"}}, now.Add(time.Hour)); started != 1 {
+		t.Errorf("got %d started, want a new visit", started)
+	}
+}
+
+func TestSessionMetrics(t *testing.T) {
+	sessions = newSessionTracker()
+	defer func() { sessions = newSessionTracker() }()
+
+	before := testutil.ToFloat64(opsMetrics.AircraftSessions())
+	now := time.Now()
+	sessionMetrics([]Aircraft{{Hex: "c05f0a"}, {Hex: "a0b1c2"}}, now)
+	sessionMetrics(nil, now.Add(time.Hour))
+
+	if got := testutil.ToFloat64(opsMetrics.AircraftSessions()) - before; got != 2 {
+		t.Errorf("got %f sessions, want 2", got)
+	}
+}
+
+func TestSessionTrackerPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	aircraft := []Aircraft{{Hex: "c05f0a", Flight: "WJA123", Latitude: 51.5, Longitude: -114, Messages: 10}}
+
+	tracker := newSessionTracker()
+	tracker.observe(aircraft, now)
+	if err := tracker.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a restart the visit carries on instead of starting again, and
+	// the repeated position is not counted twice.
+	restored := newSessionTracker()
+	if err := restored.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if started, _ := restored.observe(aircraft, now.Add(time.Minute)); started != 0 {
+		t.Errorf("got %d visits started after a restart, want 0", started)
+	}
+	_, ended := restored.observe(nil, now.Add(time.Hour))
+	if len(ended) != 1 {
+		t.Fatalf("got %d visits ended, want 1", len(ended))
+	}
+	s := ended[0]
+	if s.Flight != "WJA123" || !s.FirstSeen.Equal(now) || !s.LastSeen.Equal(now.Add(time.Minute)) || s.Positions != 1 {
+		t.Errorf("got visit %+v", s)
+	}
+
+	if err := newSessionTracker().load(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("got %v loading a missing file", err)
+	}
+}
+
+func TestReadAircraftFileErrorKeepsSessions(t *testing.T) {
+	useFreshTrackers(t)
+	sessions.timeout = 100 * time.Millisecond
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "aircraft.json")
+	if err := os.WriteFile(path, []byte(`{"now": 0, "messages": 10, "aircraft": [{"hex": "c05f0a", "seen": 0}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	readAircraftFile(dir + "/")
+
+	// A failed read is not a read without aircraft, so it ends no visits
+	// even once they are past the timeout.
+	time.Sleep(2 * sessions.timeout)
+	if err := os.WriteFile(path, []byte(`{"now": 0, "mess`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	readAircraftFile(dir + "/")
+
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	if _, ok := sessions.active["c05f0a"]; !ok {
+		t.Error("failed read ended the visit")
+	}
+}
@@ -1,40 +1,215 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	streamDialTimeout = 10 * time.Second
+	streamReadTimeout = 60 * time.Second
+	streamMinBackoff  = 1 * time.Second
+	streamMaxBackoff  = 60 * time.Second
 )
 
-func stream() {
-	fmt.Println("Hello, playground")
+// SBS-1 (BaseStation) field positions of a MSG record.
+const (
+	sbsMessageType      = 0
+	sbsTransmissionType = 1
+	sbsHexIdent         = 4
+	sbsCallsign         = 10
+	sbsAltitude         = 11
+	sbsGroundSpeed      = 12
+	sbsTrack            = 13
+	sbsLatitude         = 14
+	sbsLongitude        = 15
+	sbsVerticalRate     = 16
+	sbsSquawk           = 17
+	sbsAlert            = 18
+	sbsEmergency        = 19
+	sbsSPI              = 20
+	sbsIsOnGround       = 21
+	sbsFieldCount       = 22
+)
+
+type sbsMessage struct {
+	Type   int
+	Hex    string
+	Fields []string
+}
+
+// deadlineConn refreshes the read deadline before every read so a feed that
+// goes silent is treated as a dropped connection.
+type deadlineConn struct {
+	net.Conn
+}
+
+func (c deadlineConn) Read(p []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+	return c.Conn.Read(p)
+}
+
+// streamConnect dials addr and hands the connection to handler, reconnecting
+// with exponential backoff whenever the dial fails or the handler returns.
+func streamConnect(source string, addr string, handler func(io.Reader) error) {
 
-	// connect to site
-	conn, err := net.Dial("tcp", "192.168.7.78:30003")
-	if err != nil {
-		fmt.Printf("failed to connect: %s\n", err)
-		return
+	backoff := streamMinBackoff
+
+	for {
+		conn, err := net.DialTimeout("tcp", addr, streamDialTimeout)
+		if err != nil {
+			opsMetrics.StreamErrors(streamLabels{Source: source}).Inc()
+			log.Error().Err(err).
+				Str("source", source).
+				Str("addr", addr).
+				Dur("retry", backoff).
+				Msg("Error connecting to stream")
+		} else {
+			opsMetrics.StreamConnects(streamLabels{Source: source}).Inc()
+			log.Info().Str("source", source).Str("addr", addr).Msg("Connected to stream")
+
+			start := time.Now()
+			err = handler(deadlineConn{conn})
+			conn.Close()
+
+			// A connection that stayed up for a while was healthy, so start
+			// the backoff over instead of growing it further.
+			if time.Since(start) > streamMaxBackoff {
+				backoff = streamMinBackoff
+			}
+			opsMetrics.StreamErrors(streamLabels{Source: source}).Inc()
+			log.Warn().Err(err).
+				Str("source", source).
+				Str("addr", addr).
+				Dur("retry", backoff).
+				Msg("Stream disconnected")
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
 	}
+}
 
-	fmt.Printf("connected\n")
+// parseSBS splits one SBS-1 CSV line into its MSG record. Other record
+// types (SEL, ID, AIR, STA, CLK) carry no aircraft state and are rejected.
+func parseSBS(line string) (sbsMessage, error) {
+	fields := strings.Split(strings.TrimSpace(line), ",")
+	if len(fields) < sbsFieldCount {
+		return sbsMessage{}, fmt.Errorf("short SBS record: %d fields", len(fields))
+	}
+	if fields[sbsMessageType] != "MSG" {
+		return sbsMessage{}, fmt.Errorf("unsupported SBS record %q", fields[sbsMessageType])
+	}
 
-	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	msgType, err := strconv.Atoi(fields[sbsTransmissionType])
+	if err != nil || msgType < 1 || msgType > 8 {
+		return sbsMessage{}, fmt.Errorf("invalid SBS transmission type %q", fields[sbsTransmissionType])
+	}
+	if fields[sbsHexIdent] == "" {
+		return sbsMessage{}, errors.New("SBS record without hex ident")
+	}
+
+	return sbsMessage{Type: msgType, Hex: fields[sbsHexIdent], Fields: fields}, nil
+}
 
-	// Send our HTTP1.1 GET /
-	nwrite, err := conn.Write([]byte("GET  HTTP/1.1\n\n"))
-	if err != nil {
-		fmt.Printf("failed to write request: %s\n", err)
+// apply copies the fields present in the message onto the aircraft. Empty
+// fields are left alone since each transmission type only carries a subset.
+func (m sbsMessage) apply(a *trackedAircraft, now time.Time) {
+
+	if v := strings.TrimSpace(m.Fields[sbsCallsign]); v != "" {
+		a.Flight = v
+	}
+	if v, err := strconv.ParseFloat(m.Fields[sbsAltitude], 64); err == nil {
+		a.AltoBaro = Altitude{Feet: int(v)}
+	}
+	if m.Fields[sbsIsOnGround] == "-1" {
+		a.AltoBaro = Altitude{Ground: true}
+	}
+	if v := strings.TrimSpace(m.Fields[sbsSquawk]); v != "" {
+		a.Squawk = v
+	}
+	if v, err := strconv.ParseFloat(m.Fields[sbsGroundSpeed], 64); err == nil {
+		a.GroundSpeed = v
+	}
+	if v, err := strconv.ParseFloat(m.Fields[sbsTrack], 64); err == nil {
+		a.Track = &v
+	}
+	if v, ok := sbsFlag(m.Fields[sbsAlert]); ok {
+		a.Alert = &v
+	}
+	if v, ok := sbsFlag(m.Fields[sbsSPI]); ok {
+		a.Spi = &v
+	}
+	if v, err := strconv.ParseFloat(m.Fields[sbsVerticalRate], 64); err == nil {
+		a.BaroRate = int16(v)
 	}
 
-	fmt.Printf("write %d bytes\n", nwrite)
-	// read data
-	// create our buffer
-	buffer := make([]byte, 2048)
-	nread, err := conn.Read(buffer)
-	if err != nil {
-		fmt.Printf("failed to read from socket: %s\n", err)
-		return
+	lat, latErr := strconv.ParseFloat(m.Fields[sbsLatitude], 64)
+	lon, lonErr := strconv.ParseFloat(m.Fields[sbsLongitude], 64)
+	if latErr == nil && lonErr == nil {
+		a.Latitude = lat
+		a.Longitude = lon
+		a.lastPos = now
 	}
-	fmt.Printf("bytes read: %d, content: %s\n", nread, buffer)
+}
+
+// sbsFlag parses a SBS boolean field, where "-1" means set and "0" clear.
+func sbsFlag(field string) (float64, bool) {
+	switch field {
+	case "-1", "1":
+		return 1, true
+	case "0":
+		return 0, true
+	}
+	return 0, false
+}
+
+// readSBS reads SBS-1 records from r into the aircraft table until the
+// stream ends or fails.
+func readSBS(r io.Reader, table *aircraftTable) error {
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		msg, err := parseSBS(line)
+		if err != nil {
+			opsMetrics.StreamErrors(streamLabels{Source: "sbs"}).Inc()
+			log.Debug().Err(err).Str("line", line).Msg("Skipping SBS record")
+			continue
+		}
+
+		opsMetrics.StreamMessages(streamMessageLabels{Source: "sbs", Type: "msg" + strconv.Itoa(msg.Type)}).Inc()
+		now := time.Now()
+		table.update(msg.Hex, now, func(a *trackedAircraft) {
+			msg.apply(a, now)
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
 
+// streamSBS keeps a connection to a SBS/BaseStation feed (dump1090 port
+// 30003) open and feeds every record into the aircraft table.
+func streamSBS(addr string, table *aircraftTable) {
+	streamConnect("sbs", addr, func(r io.Reader) error {
+		return readSBS(r, table)
+	})
 }
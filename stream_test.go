@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSBS(t *testing.T) {
+	var tests = []struct {
+		line    string
+		wantErr bool
+		msgType int
+	}{
+		{"MSG,3,1,1,4840D6,1,2023/01/01,12:00:00.000,2023/01/01,12:00:00.000,,37000,,,51.0,-114.0,,,0,0,0,0", false, 3},
+		{"MSG,1,1,1,C0FFEE,1,2023/01/01,12:00:00.000,2023/01/01,12:00:00.000,WJA123  ,,,,,,,,0,0,0,0", false, 1},
+		{"STA,,1,1,4840D6,1,2023/01/01,12:00:00.000,2023/01/01,12:00:00.000,RM", true, 0},
+		{"MSG,9,1,1,4840D6,1,,,,,,,,,,,,,,,,", true, 0},
+		{"MSG,3,1,1,,1,,,,,,,,,,,,,,,,", true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			msg, err := parseSBS(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && msg.Type != tt.msgType {
+				t.Errorf("got type %d, want %d", msg.Type, tt.msgType)
+			}
+		})
+	}
+}
+
+func TestAircraftTableSBS(t *testing.T) {
+	table := newAircraftTable()
+	now := time.Now()
+
+	for _, line := range []string{
+		"MSG,1,1,1,C0FFEE,1,,,,,WJA123  ,,,,,,,,0,0,0,0",
+		"MSG,3,1,1,C0FFEE,1,,,,,,37000,,,51.0,-114.0,,,0,0,0,0",
+		"MSG,4,1,1,C0FFEE,1,,,,,,,450,270,,,-640,,0,0,0,0",
+	} {
+		msg, err := parseSBS(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		table.update(msg.Hex, now, func(a *trackedAircraft) {
+			msg.apply(a, now)
+		})
+	}
+
+	list := table.snapshot(now.Add(2 * time.Second))
+	if len(list.Aircraft) != 1 {
+		t.Fatalf("got %d aircraft, want 1", len(list.Aircraft))
+	}
+	a := list.Aircraft[0]
+	if a.Hex != "c0ffee" || a.Flight != "WJA123" || a.AltoBaro.Feet != 37000 || a.GroundSpeed != 450 || a.BaroRate != -640 {
+		t.Errorf("unexpected aircraft %+v", a)
+	}
+	if a.Latitude != 51.0 || a.Longitude != -114.0 || a.Seen != 2 || a.SeenPos != 2 || a.Messages != 3 {
+		t.Errorf("unexpected position state %+v", a)
+	}
+
+	// An aircraft without a position is not counted as positioned.
+	msg, err := parseSBS("MSG,1,1,1,C0FFEF,1,,,,,ACA456  ,,,,,,,,0,0,0,0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.update(msg.Hex, now, func(a *trackedAircraft) {
+		msg.apply(a, now)
+	})
+	for _, a := range table.snapshot(now.Add(2 * time.Second)).Aircraft {
+		if a.Hex == "c0ffef" && a.SeenPos < positionMaxAge {
+			t.Errorf("got seen_pos %f for an aircraft without a position", a.SeenPos)
+		}
+	}
+
+	if list := table.snapshot(now.Add(aircraftExpiry + time.Second)); len(list.Aircraft) != 0 {
+		t.Errorf("expired aircraft still in table")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aircraftExpiry is how long an aircraft stays in the live table after its
+// last message. dump1090 drops aircraft from aircraft.json after the same time.
+const aircraftExpiry = 300 * time.Second
+
+type trackedAircraft struct {
+	Aircraft
+	lastSeen time.Time
+	lastPos  time.Time
+	cpr      [2]cprFrame
+}
+
+// aircraftTable is the live view of aircraft built from a network feed. It
+// stands in for aircraft.json when the receiver only exposes a raw stream.
+type aircraftTable struct {
+	mu       sync.Mutex
+	aircraft map[string]*trackedAircraft
+	messages float64
+}
+
+func newAircraftTable() *aircraftTable {
+	return &aircraftTable{aircraft: make(map[string]*trackedAircraft)}
+}
+
+// update applies fn to the aircraft with the given hex, creating the entry if
+// it is new, and counts one message for it.
+func (t *aircraftTable) update(hex string, now time.Time, fn func(a *trackedAircraft)) {
+	hex = strings.ToLower(strings.TrimSpace(hex))
+	if hex == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.aircraft[hex]
+	if !ok {
+		a = &trackedAircraft{Aircraft: Aircraft{Hex: hex}}
+		t.aircraft[hex] = a
+	}
+	a.lastSeen = now
+	a.Messages++
+	t.messages++
+	fn(a)
+}
+
+// known reports whether the aircraft is currently in the table.
+func (t *aircraftTable) known(hex string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.aircraft[strings.ToLower(hex)]
+	return ok
+}
+
+// snapshot returns the table in the same shape as aircraft.json, dropping
+// aircraft that have not been heard from within aircraftExpiry.
+func (t *aircraftTable) snapshot(now time.Time) AircraftList {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := AircraftList{
+		Messages: t.messages,
+		Aircraft: make([]Aircraft, 0, len(t.aircraft)),
+	}
+	for hex, a := range t.aircraft {
+		if now.Sub(a.lastSeen) > aircraftExpiry {
+			delete(t.aircraft, hex)
+			continue
+		}
+		ac := a.Aircraft
+		ac.Seen = now.Sub(a.lastSeen).Seconds()
+		if a.lastPos.IsZero() {
+			// A zero seen_pos reads as a fresh position, so an aircraft
+			// that never had one gets one older than any the metrics use.
+			ac.SeenPos = aircraftExpiry.Seconds()
+		} else {
+			ac.SeenPos = now.Sub(a.lastPos).Seconds()
+		}
+		list.Aircraft = append(list.Aircraft, ac)
+	}
+	sort.Slice(list.Aircraft, func(i, j int) bool {
+		return list.Aircraft[i].Hex < list.Aircraft[j].Hex
+	})
+
+	return list
+}
+
+// tableTicker feeds the live aircraft table into aircraftMetrics on the same
+// cadence readFilesTicker uses for aircraft.json.
+func tableTicker(table *aircraftTable) {
+
+	aircraftTicker := time.NewTicker(5 * time.Second)
+
+	for {
+		<-aircraftTicker.C
+		aircraftMetrics(table.snapshot(time.Now()))
+	}
+}
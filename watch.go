@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// watchDebounce collapses the burst of events dump1090 generates while
+	// replacing a file into a single read.
+	watchDebounce = 250 * time.Millisecond
+	// watchFallbackInterval re-reads the files even without events so a
+	// missed notification can not leave the metrics stale for long.
+	watchFallbackInterval = 60 * time.Second
+	watchRetry            = 5 * time.Second
+)
+
+// watchFiles re-reads aircraft.json and stats.json whenever dump1090 replaces
+// them. Remote paths and platforms without inotify fall back to
+// readFilesTicker.
+func watchFiles(path string, readAircraft bool) {
+
+	if isURL(path) {
+		log.Info().Msg("Path is a URL, polling instead of watching")
+		readFilesTicker(path, readAircraft)
+		return
+	}
+
+	readers := map[string]func(string){
+		"stats.json": readStatsFile,
+	}
+	if readAircraft {
+		readers["aircraft.json"] = readAircraftFile
+	}
+
+	// Debounced reads run on their own goroutines; keep them from
+	// interleaving with each other and with the fallback reads.
+	var mu sync.Mutex
+
+	readAll := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, read := range readers {
+			read(path)
+		}
+	}
+
+	events, err := watchDir(path)
+	if err != nil {
+		log.Error().Err(err).Msg("Error watching path, polling instead")
+		readFilesTicker(path, readAircraft)
+		return
+	}
+
+	readAll()
+
+	fallbackTicker := time.NewTicker(watchFallbackInterval)
+	timers := make(map[string]*time.Timer)
+
+	for {
+		select {
+		case name, ok := <-events:
+			if !ok {
+				log.Warn().Msg("Watch on path lost, re-establishing")
+				events = rewatchDir(path)
+				readAll()
+				continue
+			}
+
+			read, found := readers[name]
+			if !found {
+				continue
+			}
+			opsMetrics.FileEvents(fileLabels{File: name}).Inc()
+			if timer, exists := timers[name]; exists {
+				timer.Reset(watchDebounce)
+				continue
+			}
+			timers[name] = time.AfterFunc(watchDebounce, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				read(path)
+			})
+		case <-fallbackTicker.C:
+			readAll()
+		}
+	}
+}
+
+// rewatchDir retries watchDir until the directory can be watched again.
+func rewatchDir(path string) <-chan string {
+	for {
+		time.Sleep(watchRetry)
+		if events, err := watchDir(path); err == nil {
+			return events
+		}
+	}
+}
@@ -0,0 +1,62 @@
+//go:build linux
+
+package main
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/rs/zerolog/log"
+)
+
+// watchDir reports the names of files in dir that are written or renamed
+// into place. dump1090-fa writes to a temporary file and renames it over the
+// old one, which shows up as IN_MOVED_TO. The channel is closed if the watch
+// is lost, for example when the directory is removed.
+func watchDir(dir string) (<-chan string, error) {
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	events := make(chan string)
+
+	go func() {
+		defer close(events)
+		defer syscall.Close(fd)
+
+		buf := make([]byte, 4096)
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				log.Error().Err(err).Msg("Error reading inotify events")
+				return
+			}
+
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				start := offset + syscall.SizeofInotifyEvent
+				offset = start + int(event.Len)
+
+				if event.Mask&syscall.IN_IGNORED != 0 {
+					return
+				}
+				name := strings.TrimRight(string(buf[start:offset]), "\x00")
+				if name != "" {
+					events <- name
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
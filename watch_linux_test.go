@@ -0,0 +1,39 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchDirRename(t *testing.T) {
+	dir := t.TempDir()
+	events, err := watchDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// dump1090-fa writes a temporary file and renames it into place.
+	tmp := filepath.Join(dir, "aircraft.json.tmp")
+	if err := os.WriteFile(tmp, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "aircraft.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case name := <-events:
+			if name == "aircraft.json" {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no event for aircraft.json")
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// watchDir is only implemented with inotify; other platforms poll.
+func watchDir(dir string) (<-chan string, error) {
+	return nil, errors.New("file watching is only supported on linux")
+}
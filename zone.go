@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var zones = newZoneTracker()
+
+// zone is a named area, a circle or a polygon, optionally limited to an
+// altitude range in feet.
+type zone struct {
+	Name string `json:"name"`
+	// Center and Radius, in metres, describe a circle.
+	Center *[2]float64 `json:"center,omitempty"`
+	Radius float64     `json:"radius,omitempty"`
+	// Polygon is a list of lat,lon vertices. The edges are straight lines
+	// in latitude and longitude, which is close enough for the few km of an
+	// approach corridor.
+	Polygon [][2]float64 `json:"polygon,omitempty"`
+	Floor   *int         `json:"floor,omitempty"`
+	Ceiling *int         `json:"ceiling,omitempty"`
+}
+
+type zoneFile struct {
+	Zones []zone `json:"zones"`
+}
+
+// loadZones reads zone definitions from a json file.
+func loadZones(path string) ([]zone, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file zoneFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, z := range file.Zones {
+		switch {
+		case z.Name == "":
+			return nil, fmt.Errorf("zone without a name")
+		case names[z.Name]:
+			return nil, fmt.Errorf("zone %s defined twice", z.Name)
+		case z.Center == nil && len(z.Polygon) < 3:
+			return nil, fmt.Errorf("zone %s needs a center and radius or a polygon of at least 3 points", z.Name)
+		case z.Center != nil && z.Radius <= 0:
+			return nil, fmt.Errorf("zone %s needs a positive radius", z.Name)
+		}
+		names[z.Name] = true
+	}
+	return file.Zones, nil
+}
+
+// contains reports whether an aircraft is inside the zone. Aircraft without
+// an altitude are only inside zones without altitude limits.
+func (z zone) contains(a Aircraft) bool {
+	if z.Floor != nil || z.Ceiling != nil {
+		alt := a.AltoBaro
+		if alt == (Altitude{}) {
+			alt = a.AltoGeom
+		}
+		if alt == (Altitude{}) {
+			return false
+		}
+		if z.Floor != nil && alt.Feet < *z.Floor {
+			return false
+		}
+		if z.Ceiling != nil && alt.Feet > *z.Ceiling {
+			return false
+		}
+	}
+
+	if z.Center != nil {
+		return distance(z.Center[0], z.Center[1], a.Latitude, a.Longitude) <= z.Radius
+	}
+
+	// Count the edges a ray heading east from the aircraft crosses.
+	inside := false
+	for i, j := 0, len(z.Polygon)-1; i < len(z.Polygon); j, i = i, i+1 {
+		latI, lonI := z.Polygon[i][0], z.Polygon[i][1]
+		latJ, lonJ := z.Polygon[j][0], z.Polygon[j][1]
+		if (latI > a.Latitude) != (latJ > a.Latitude) &&
+			a.Longitude < (lonJ-lonI)*(a.Latitude-latI)/(latJ-latI)+lonI {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+type zoneEvent struct {
+	Event  string    `json:"event"`
+	Zone   string    `json:"zone"`
+	Hex    string    `json:"hex"`
+	Flight string    `json:"flight,omitempty"`
+	Time   time.Time `json:"time"`
+	// Duration is how long the aircraft was in the zone, on exit.
+	Duration float64 `json:"duration_seconds,omitempty"`
+}
+
+type zoneVisit struct {
+	flight   string
+	since    time.Time
+	lastSeen time.Time
+}
+
+// zoneTracker follows aircraft in and out of zones across reads. An
+// aircraft leaves a zone when it is positioned outside it, or when it has
+// not been positioned for exitAfter, so a few missed positions do not count
+// as a new approach.
+type zoneTracker struct {
+	mu     sync.Mutex
+	zones  []zone
+	inside map[string]map[string]*zoneVisit
+
+	webhooks  []string
+	exitAfter time.Duration
+}
+
+func newZoneTracker() *zoneTracker {
+	return &zoneTracker{
+		inside:    make(map[string]map[string]*zoneVisit),
+		exitAfter: time.Minute,
+	}
+}
+
+func (t *zoneTracker) setZones(list []zone) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.zones = list
+	t.inside = make(map[string]map[string]*zoneVisit)
+	for _, z := range list {
+		t.inside[z.Name] = make(map[string]*zoneVisit)
+	}
+}
+
+// observe updates the tracker from one read of aircraft, returning the
+// number of aircraft in each zone and the entry and exit events.
+func (t *zoneTracker) observe(aircraft []Aircraft, now time.Time) (map[string]int, []zoneEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counts := make(map[string]int)
+	var events []zoneEvent
+
+	for _, z := range t.zones {
+		visits := t.inside[z.Name]
+		counts[z.Name] = 0
+
+		for _, a := range aircraft {
+			if a.SeenPos >= positionMaxAge || (a.Latitude == 0 && a.Longitude == 0) {
+				continue
+			}
+			flight := strings.TrimSpace(a.Flight)
+			visit, ok := visits[a.Hex]
+
+			if !z.contains(a) {
+				if ok {
+					delete(visits, a.Hex)
+					events = append(events, visit.event("zone_exit", z.Name, a.Hex, now))
+				}
+				continue
+			}
+
+			counts[z.Name]++
+			if !ok {
+				visit = &zoneVisit{since: now}
+				visits[a.Hex] = visit
+				events = append(events, zoneEvent{Event: "zone_enter", Zone: z.Name, Hex: a.Hex, Flight: flight, Time: now})
+			}
+			visit.lastSeen = now
+			if flight != "" {
+				visit.flight = flight
+			}
+		}
+
+		for hex, visit := range visits {
+			if now.Sub(visit.lastSeen) >= t.exitAfter {
+				// The aircraft was last known inside when it was last
+				// positioned, not when the timeout ran out.
+				delete(visits, hex)
+				events = append(events, visit.event("zone_exit", z.Name, hex, visit.lastSeen))
+			}
+		}
+	}
+
+	return counts, events
+}
+
+func (v *zoneVisit) event(name string, zone string, hex string, now time.Time) zoneEvent {
+	return zoneEvent{
+		Event:    name,
+		Zone:     zone,
+		Hex:      hex,
+		Flight:   v.flight,
+		Time:     now,
+		Duration: now.Sub(v.since).Seconds(),
+	}
+}
+
+// notify logs the event and posts it to every configured webhook.
+func (t *zoneTracker) notify(event zoneEvent) {
+
+	opsMetrics.ZoneEvents(zoneLabels{Zone: event.Zone, Event: event.Event}).Inc()
+	log.Info().
+		Str("event", event.Event).
+		Str("zone", event.Zone).
+		Str("hex", event.Hex).
+		Str("flight", event.Flight).
+		Msg("Aircraft zone")
+
+	if len(t.webhooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Error().Err(err).Msg("Error encoding zone event")
+		return
+	}
+	for _, url := range t.webhooks {
+		go func(url string) {
+			if err := postWebhook(url, body); err != nil {
+				log.Error().Err(err).Str("url", url).Msg("Error sending zone webhook")
+			}
+		}(url)
+	}
+}
+
+// zoneMetrics places the aircraft of one read in the configured zones.
+func zoneMetrics(aircraft []Aircraft, now time.Time) {
+
+	counts, events := zones.observe(aircraft, now)
+	for _, event := range events {
+		zones.notify(event)
+	}
+
+	dump1090ZoneAircraft.Reset()
+	for name, count := range counts {
+		dump1090ZoneAircraft.With(prometheus.Labels{"zone": name}).Set(float64(count))
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testZones = `{"zones": [
+	{"name": "airport", "center": [51.1315, -114.0106], "radius": 5000},
+	{"name": "final-35r", "polygon": [[51.00, -114.03], [51.00, -114.00], [51.12, -114.00], [51.12, -114.03]], "floor": 1000, "ceiling": 6000}
+]}`
+
+func writeTestZones(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "zones.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadZones(t *testing.T) {
+	list, err := loadZones(writeTestZones(t, testZones))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[1].Floor == nil || *list[1].Ceiling != 6000 {
+		t.Errorf("unexpected zones %+v", list)
+	}
+
+	for _, bad := range []string{
+		`{"zones": [{"center": [51, -114], "radius": 5000}]}`,
+		`{"zones": [{"name": "a", "center": [51, -114]}]}`,
+		`{"zones": [{"name": "a", "polygon": [[51, -114], [52, -114]]}]}`,
+		`{"zones": [{"name": "a", "center": [51, -114], "radius": 1}, {"name": "a", "center": [51, -114], "radius": 1}]}`,
+	} {
+		if _, err := loadZones(writeTestZones(t, bad)); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestZoneContains(t *testing.T) {
+	list, err := loadZones(writeTestZones(t, testZones))
+	if err != nil {
+		t.Fatal(err)
+	}
+	airport, final := list[0], list[1]
+
+	var tests = []struct {
+		name     string
+		zone     zone
+		aircraft Aircraft
+		want     bool
+	}{
+		{"on the airport", airport, Aircraft{Latitude: 51.13, Longitude: -114.01}, true},
+		{"outside the circle", airport, Aircraft{Latitude: 51.2, Longitude: -114.01}, false},
+		{"on final", final, Aircraft{Latitude: 51.05, Longitude: -114.015, AltoBaro: Altitude{Feet: 4000}}, true},
+		{"below the floor", final, Aircraft{Latitude: 51.05, Longitude: -114.015, AltoBaro: Altitude{Feet: 500}}, false},
+		{"above the ceiling", final, Aircraft{Latitude: 51.05, Longitude: -114.015, AltoGeom: Altitude{Feet: 8000}}, false},
+		{"no altitude", final, Aircraft{Latitude: 51.05, Longitude: -114.015}, false},
+		{"beside final", final, Aircraft{Latitude: 51.05, Longitude: -114.05, AltoBaro: Altitude{Feet: 4000}}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.zone.contains(tt.aircraft); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestZoneTracker(t *testing.T) {
+	list, err := loadZones(writeTestZones(t, testZones))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zones.setZones(list)
+	defer zones.setZones(nil)
+
+	now := time.Now()
+	onFinal := Aircraft{Hex: "c05f0a", Flight: "WJA123  ", Latitude: 51.05, Longitude: -114.015, AltoBaro: Altitude{Feet: 4000}}
+	landed := Aircraft{Hex: "c05f0a", Latitude: 51.13, Longitude: -114.01, AltoBaro: Altitude{Ground: true}}
+
+	zoneMetrics([]Aircraft{onFinal}, now)
+	if got := testutil.ToFloat64(dump1090ZoneAircraft.WithLabelValues("final-35r")); got != 1 {
+		t.Errorf("got %f aircraft on final, want 1", got)
+	}
+	if got := testutil.ToFloat64(dump1090ZoneAircraft.WithLabelValues("airport")); got != 0 {
+		t.Errorf("got %f aircraft on the airport, want 0", got)
+	}
+
+	counts, events := zones.observe([]Aircraft{landed}, now.Add(time.Minute))
+	if counts["airport"] != 1 || counts["final-35r"] != 0 {
+		t.Errorf("got counts %v", counts)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got events %+v, want an exit and an entry", events)
+	}
+	for _, event := range events {
+		switch event.Event {
+		case "zone_exit":
+			if event.Zone != "final-35r" || event.Flight != "WJA123" || event.Duration != 60 {
+				t.Errorf("unexpected exit %+v", event)
+			}
+		case "zone_enter":
+			if event.Zone != "airport" {
+				t.Errorf("unexpected entry %+v", event)
+			}
+		}
+	}
+
+	// An aircraft that stops reporting leaves after exitAfter, at the time
+	// it was last positioned.
+	zones.observe([]Aircraft{landed}, now.Add(75*time.Second))
+	if _, events := zones.observe(nil, now.Add(2*time.Minute)); len(events) != 0 {
+		t.Errorf("got events %+v before exitAfter", events)
+	}
+	_, events = zones.observe(nil, now.Add(135*time.Second))
+	if len(events) != 1 || events[0].Event != "zone_exit" {
+		t.Fatalf("got events %+v, want an exit", events)
+	}
+	if !events[0].Time.Equal(now.Add(75*time.Second)) || events[0].Duration != 15 {
+		t.Errorf("got exit at %s after %fs, want the last position after 15s", events[0].Time, events[0].Duration)
+	}
+}
+
+func TestZoneWebhook(t *testing.T) {
+	list, err := loadZones(writeTestZones(t, testZones))
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, bodies := webhookServer(t)
+	zones.setZones(list)
+	zones.webhooks = []string{url}
+	defer func() {
+		zones.setZones(nil)
+		zones.webhooks = nil
+		dump1090ZoneAircraft.Reset()
+	}()
+
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	onFinal := []Aircraft{{Hex: "c05f0a", Flight: "WJA123  ", Latitude: 51.05, Longitude: -114.015, AltoBaro: Altitude{Feet: 4000}}}
+	zoneMetrics(onFinal, now)
+	// Staying in the zone does not post again.
+	zoneMetrics(onFinal, now.Add(5*time.Second))
+
+	var event zoneEvent
+	if err := json.Unmarshal(nextWebhook(t, bodies), &event); err != nil {
+		t.Fatal(err)
+	}
+	want := zoneEvent{Event: "zone_enter", Zone: "final-35r", Hex: "c05f0a", Flight: "WJA123", Time: now}
+	if event != want {
+		t.Errorf("got %+v, want %+v", event, want)
+	}
+
+	select {
+	case body := <-bodies:
+		t.Errorf("got a second webhook %s", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestReadAircraftFileError(t *testing.T) {
+	list, err := loadZones(writeTestZones(t, testZones))
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFreshTrackers(t)
+	zones.setZones(list)
+	defer dump1090ZoneAircraft.Reset()
+
+	dir := t.TempDir()
+	onFinal := `{"now": 0, "messages": 10, "aircraft": [{"hex": "c05f0a", "lat": 51.05, "lon": -114.015, "alt_baro": 4000}]}`
+	if err := os.WriteFile(filepath.Join(dir, "aircraft.json"), []byte(onFinal), 0644); err != nil {
+		t.Fatal(err)
+	}
+	readAircraftFile(dir + "/")
+	if got := testutil.ToFloat64(dump1090ZoneAircraft.WithLabelValues("final-35r")); got != 1 {
+		t.Fatalf("got %f aircraft on final, want 1", got)
+	}
+
+	// A read that fails, here on a file dump1090 is half way through
+	// writing, leaves the zones alone.
+	if err := os.WriteFile(filepath.Join(dir, "aircraft.json"), []byte(onFinal[:40]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	readAircraftFile(dir + "/")
+	if got := testutil.ToFloat64(dump1090ZoneAircraft.WithLabelValues("final-35r")); got != 1 {
+		t.Errorf("got %f aircraft on final after a failed read, want 1", got)
+	}
+}